@@ -0,0 +1,49 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+)
+
+// lightColor returns the colour of the light module at x, y of the bitmap:
+// BackgroundColor, or transparent with the Transparent option unless the
+// module is backed.
+func (q *HalftoneQRCode) lightColor(x, y int) color.Color {
+	if !q.option.Transparent {
+		return q.option.BackgroundColor
+	} else if q.isBacked(x, y) {
+		return opaque(q.option.BackgroundColor)
+	}
+
+	return color.Transparent
+}
+
+// isBacked reports whether the module at x, y of the bitmap is drawn on an
+// opaque background with the Transparent option: the modules of the quiet zone
+// and of the finder patterns and their separators.
+func (q *HalftoneQRCode) isBacked(x, y int) bool {
+	if q.inQuietZone(x, y) {
+		return true
+	}
+
+	p := image.Pt(x, y)
+	for _, r := range q.symbol.finderPatterns {
+		// The separator is a module wide.
+		if p.In(r.Inset(-1)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// opaque returns c without transparency, white if c is fully transparent.
+func opaque(c color.Color) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0 {
+		return color.White
+	}
+	n.A = 0xff
+
+	return n
+}
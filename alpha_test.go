@@ -0,0 +1,135 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testTransparentImage returns a PNG image, red and half transparent on the
+// left half, fully transparent on the right half.
+func testTransparentImage() []byte {
+	m := image.NewNRGBA(image.Rect(0, 0, 300, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 150; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{0xff, 0, 0, 0x80})
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, m)
+
+	return buf.Bytes()
+}
+
+func TestTransparent(t *testing.T) {
+	const moduleWidth = 9
+
+	q, err := NewHalftoneCodeWithOption("hello", Highest, EncodeOption{Version: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{Transparent: true, Eyes: &EyeStyle{}})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x*moduleWidth+moduleWidth/2, y*moduleWidth+moduleWidth/2)).(color.RGBA)
+	}
+
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+
+	for y, row := range q.Bitmap() {
+		for x, v := range row {
+			expected := black
+			switch {
+			case v:
+			case q.isBacked(x, y):
+				expected = white
+			default:
+				expected = color.RGBA{}
+			}
+
+			if c := at(x, y); c != expected {
+				t.Fatalf("got module %d,%d %v, expected %v", x, y, c, expected)
+			}
+		}
+	}
+
+	// The quiet zone, the finder patterns and their separators are backed.
+	for _, p := range []image.Point{{0, 0}, {11, 11}, {4, 11}, {32, 32}} {
+		if !q.isBacked(p.X, p.Y) {
+			t.Errorf("got module %v not backed, expected backed", p)
+		}
+	}
+	for _, p := range []image.Point{{13, 13}, {12, 4}, {23, 23}} {
+		if q.isBacked(p.X, p.Y) {
+			t.Errorf("got module %v backed, expected transparent", p)
+		}
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected the code to scan over white", err.Error())
+	}
+}
+
+func TestTransparentMaskImage(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testTransparentImage())})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mask image is composited over the background colour around the
+	// module cores: opaque, pink on the left half.
+	bounds := img.Bounds()
+	pink := color.RGBA{0xff, 0x7f, 0x7f, 0xff}
+	found := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A != 0xff {
+				t.Fatalf("got %v at %d,%d, expected opaque", c, x, y)
+			}
+			if c == pink {
+				if x >= bounds.Dx()/2 {
+					t.Fatalf("got %v at %d,%d, expected the transparent half", c, x, y)
+				}
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("got no pixel %v, expected the mask image over the background", pink)
+	}
+
+	// Embed mode keeps the transparency of the mask image around the code.
+	q.AddOption(Option{Embed: true, MaskRectangle: image.Rect(100, 100, 200, 200)})
+
+	if img, err = q.CodeImage(3); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := color.NRGBAModel.Convert(img.At(50, 50)).(color.NRGBA); c != (color.NRGBA{0xff, 0, 0, 0x80}) {
+		t.Errorf("got %v out of the code, expected the mask image", c)
+	}
+	if c := color.NRGBAModel.Convert(img.At(250, 250)).(color.NRGBA); c.A != 0 {
+		t.Errorf("got %v out of the code, expected transparent", c)
+	}
+	if c := color.NRGBAModel.Convert(img.At(101, 101)).(color.NRGBA); c.A != 0xff {
+		t.Errorf("got %v in the quiet zone, expected opaque", c)
+	}
+}
@@ -0,0 +1,592 @@
+package qart
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/xrlin/qart/reedsolomon"
+)
+
+// DecodedCode is the content and metadata read back from a QR Code.
+type DecodedCode struct {
+	// Content encoded in the symbol.
+	Content string
+
+	// QR Code version number and error recovery level.
+	VersionNumber int
+	Level         RecoveryLevel
+
+	// Data mask pattern (0-7).
+	Mask int
+
+	// Character set declared by an ECI segment, NoCharset if none.
+	Charset Charset
+
+	// FNC1 mode of the data, and the application indicator of FNC1Second.
+	FNC1                 FNC1Mode
+	ApplicationIndicator string
+
+	// Position of the symbol in its Structured Append sequence, the number of
+	// symbols in the sequence and the parity of the complete content.
+	// StructuredAppendTotal is 0 if the symbol is not part of a sequence.
+	StructuredAppendIndex  int
+	StructuredAppendTotal  int
+	StructuredAppendParity byte
+
+	// Number of codewords fixed by error correction.
+	NumCorrected int
+}
+
+// maxFormatInfoErrors and maxVersionInfoErrors are the number of bit errors
+// the BCH codes protecting the Format and Version Information correct.
+const (
+	maxFormatInfoErrors  = 3
+	maxVersionInfoErrors = 3
+)
+
+// DecodeBitmap decodes the QR Code in bitmap, a square matrix of modules such as
+// the one returned by HalftoneQRCode.Bitmap. bitmap[y][x] is true if the module
+// at (x, y) is dark. The quiet zone around the symbol is optional.
+//
+// The Format and Version Information are read with error correction, and the
+// data is error corrected before the segments are decoded.
+//
+// An error occurs if bitmap does not contain a QR Code, or if the code is too
+// damaged to decode.
+func DecodeBitmap(bitmap [][]bool) (*DecodedCode, error) {
+	modules, err := trimQuietZone(bitmap)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeModules(modules)
+}
+
+// decodeModules decodes the QR Code in modules, a square matrix of modules
+// without quiet zone.
+func decodeModules(modules [][]bool) (*DecodedCode, error) {
+	var err error
+
+	size := len(modules)
+	if size < 21 || size > 177 || (size-17)%4 != 0 {
+		return nil, fmt.Errorf("invalid symbol size %d", size)
+	}
+
+	versionNumber := (size - 17) / 4
+	if versionNumber >= 7 {
+		versionNumber, err = readVersionInfo(modules)
+		if err != nil {
+			return nil, err
+		} else if 17+4*versionNumber != size {
+			return nil, errors.New("version information does not match the symbol size")
+		}
+	}
+
+	level, mask, err := readFormatInfo(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	version := getQRCodeVersion(level, versionNumber)
+
+	codewords := readCodewords(modules, *version, mask)
+
+	data, numCorrected, err := correctBlocks(codewords, *version)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DecodedCode{
+		VersionNumber: versionNumber,
+		Level:         level,
+		Mask:          mask,
+		NumCorrected:  numCorrected,
+	}
+
+	if err := parseSegments(data, version.dataEncoderType, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// trimQuietZone returns the part of bitmap from the first to the last row and
+// column containing a dark module. The finder patterns guarantee dark modules
+// on every edge of a QR Code.
+func trimQuietZone(bitmap [][]bool) ([][]bool, error) {
+	minX, minY, maxX, maxY := len(bitmap), len(bitmap), -1, -1
+
+	for y, row := range bitmap {
+		if len(row) != len(bitmap) {
+			return nil, errors.New("bitmap is not square")
+		}
+
+		for x, v := range row {
+			if !v {
+				continue
+			}
+
+			if x < minX {
+				minX = x
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if y > maxY {
+				maxY = y
+			}
+		}
+	}
+
+	if maxX < 0 || maxX-minX != maxY-minY {
+		return nil, errors.New("no QR Code found in bitmap")
+	}
+
+	modules := make([][]bool, maxY-minY+1)
+	for i := range modules {
+		modules[i] = bitmap[minY+i][minX : maxX+1]
+	}
+
+	return modules, nil
+}
+
+// readFormatInfo reads the error recovery level and data mask pattern from the
+// two copies of the Format Information, see HalftoneRegularSymbol.addFormatInfo.
+func readFormatInfo(modules [][]bool) (RecoveryLevel, int, error) {
+	size := len(modules)
+	fpSize := finderPatternSize
+
+	// bit returns 1 << i if the module at (x, y) is dark.
+	bit := func(x int, y int, i uint) uint32 {
+		if modules[y][x] {
+			return 1 << i
+		}
+
+		return 0
+	}
+
+	var first, second uint32
+
+	for i := 0; i <= 5; i++ {
+		first |= bit(fpSize+1, i, uint(i))
+	}
+	first |= bit(fpSize+1, fpSize, 6)
+	first |= bit(fpSize+1, fpSize+1, 7)
+	first |= bit(fpSize, fpSize+1, 8)
+	for i := 9; i <= 14; i++ {
+		first |= bit(14-i, fpSize+1, uint(i))
+	}
+
+	for i := 0; i <= 7; i++ {
+		second |= bit(size-i-1, fpSize+1, uint(i))
+	}
+	for i := 8; i <= 14; i++ {
+		second |= bit(fpSize+1, size-fpSize+i-8, uint(i))
+	}
+
+	// Find the closest valid Format Information value.
+	formatID, distance := -1, maxFormatInfoErrors+1
+	for id, f := range formatBitSequence {
+		for _, v := range []uint32{first, second} {
+			if d := bits.OnesCount32(v ^ f.regular); d < distance {
+				formatID, distance = id, d
+			}
+		}
+	}
+
+	if formatID < 0 {
+		return 0, 0, errors.New("unable to read format information")
+	}
+
+	var level RecoveryLevel
+	switch formatID >> 3 {
+	case 0x1:
+		level = Low
+	case 0x0:
+		level = Medium
+	case 0x3:
+		level = High
+	case 0x2:
+		level = Highest
+	}
+
+	return level, formatID & 0x7, nil
+}
+
+// readVersionInfo reads the version number from the two copies of the Version
+// Information, see HalftoneRegularSymbol.addVersionInfo.
+func readVersionInfo(modules [][]bool) (int, error) {
+	size := len(modules)
+	fpSize := finderPatternSize
+
+	var first, second uint32
+	for i := 0; i < versionInfoLengthBits; i++ {
+		if modules[size-fpSize-4+i%3][i/3] {
+			first |= 1 << uint(i)
+		}
+
+		if modules[i/3][size-fpSize-4+i%3] {
+			second |= 1 << uint(i)
+		}
+	}
+
+	version, distance := -1, maxVersionInfoErrors+1
+	for v := 7; v < len(versionBitSequence); v++ {
+		for _, info := range []uint32{first, second} {
+			if d := bits.OnesCount32(info ^ versionBitSequence[v]); d < distance {
+				version, distance = v, d
+			}
+		}
+	}
+
+	if version < 0 {
+		return 0, errors.New("unable to read version information")
+	}
+
+	return version, nil
+}
+
+// readCodewords reads and unmasks the data and error correction codewords of
+// the symbol, in placement order.
+func readCodewords(modules [][]bool, version qrCodeVersion, mask int) []byte {
+	numCodewords := 0
+	for _, b := range version.block {
+		numCodewords += b.numBlocks * b.numCodewords
+	}
+
+	codewords := make([]byte, numCodewords)
+
+	// The function patterns of the version identify the data modules.
+	m := newHalftoneRegularSymbol(version, mask)
+	m.walkDataModules(numCodewords*8, func(i int, x int, y int) {
+		if modules[y][x] != dataMask(mask, x, y) {
+			codewords[i/8] |= 0x80 >> uint(i%8)
+		}
+	})
+
+	return codewords
+}
+
+// codewordBlocks returns the index of every codeword of version in placement
+// order, grouped by block: blocks[i][j] is the index of the jth codeword of the
+// ith block. The codewords of each block are its data codewords followed by
+// its error correction codewords, see HalftoneQRCode.encodeBlocks.
+func codewordBlocks(version qrCodeVersion) [][]int {
+	var blocks [][]int
+	var numDataCodewords []int
+
+	for _, b := range version.block {
+		for j := 0; j < b.numBlocks; j++ {
+			blocks = append(blocks, make([]int, 0, b.numCodewords))
+			numDataCodewords = append(numDataCodewords, b.numDataCodewords)
+		}
+	}
+
+	// Data codewords are interleaved first, then error correction codewords.
+	index := 0
+	for _, ec := range []bool{false, true} {
+		for i := 0; ; i++ {
+			working := false
+
+			for j := range blocks {
+				n := numDataCodewords[j]
+				if ec {
+					n = cap(blocks[j]) - numDataCodewords[j]
+				}
+
+				if i >= n {
+					continue
+				}
+
+				blocks[j] = append(blocks[j], index)
+				index++
+				working = true
+			}
+
+			if !working {
+				break
+			}
+		}
+	}
+
+	return blocks
+}
+
+// correctBlocks de-interleaves the codewords into blocks, corrects the errors
+// of each block and returns the concatenated data codewords.
+func correctBlocks(codewords []byte, version qrCodeVersion) ([]byte, int, error) {
+	var data []byte
+	numCorrected := 0
+
+	blockID := 0
+	blocks := codewordBlocks(version)
+
+	for _, b := range version.block {
+		for j := 0; j < b.numBlocks; j++ {
+			block := make([]byte, len(blocks[blockID]))
+			for k, index := range blocks[blockID] {
+				block[k] = codewords[index]
+			}
+
+			n, err := reedsolomon.Decode(block, b.numCodewords-b.numDataCodewords, nil)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			numCorrected += n
+			data = append(data, block[:b.numDataCodewords]...)
+
+			blockID++
+		}
+	}
+
+	return data, numCorrected, nil
+}
+
+// bitReader reads big-endian bit fields from a byte slice.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+// remaining returns the number of bits left to read.
+func (r *bitReader) remaining() int {
+	return len(r.data)*8 - r.pos
+}
+
+// read returns the next n bits.
+func (r *bitReader) read(n int) (uint32, error) {
+	if n > r.remaining() {
+		return 0, errors.New("unexpected end of data")
+	}
+
+	var v uint32
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.data[r.pos/8]&(0x80>>uint(r.pos%8)) != 0 {
+			v |= 1
+		}
+
+		r.pos++
+	}
+
+	return v, nil
+}
+
+// alphanumericCharacters maps QR Code alphanumeric values to characters.
+const alphanumericCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// parseSegments decodes the segments of data into result.
+func parseSegments(data []byte, t dataEncoderType, result *DecodedCode) error {
+	d := newDataEncoder(t)
+	r := &bitReader{data: data}
+
+	var content []byte
+
+	for r.remaining() >= 4 {
+		mode, _ := r.read(4)
+
+		var err error
+
+		switch mode {
+		case 0x0:
+			// Terminator.
+			result.Content = string(content)
+			return nil
+		case 0x1:
+			content, err = parseNumericSegment(r, d.numNumericCharCountBits, content)
+		case 0x2:
+			content, err = parseAlphanumericSegment(r, d.numAlphanumericCharCountBits,
+				result.FNC1 != NoFNC1, content)
+		case 0x4:
+			content, err = parseByteSegment(r, d.numByteCharCountBits, content)
+		case 0x8:
+			content, err = parseKanjiSegment(r, d.numKanjiCharCountBits, content)
+		case 0x7:
+			result.Charset, err = parseECI(r)
+		case 0x3:
+			var v uint32
+			if v, err = r.read(16); err == nil {
+				result.StructuredAppendIndex = int(v >> 12)
+				result.StructuredAppendTotal = int(v>>8&0xf) + 1
+				result.StructuredAppendParity = byte(v)
+			}
+		case 0x5:
+			result.FNC1 = FNC1First
+		case 0x9:
+			result.FNC1 = FNC1Second
+
+			var v uint32
+			if v, err = r.read(8); err == nil {
+				if v >= 100 {
+					result.ApplicationIndicator = string(rune(v - 100))
+				} else {
+					result.ApplicationIndicator = fmt.Sprintf("%02d", v)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown mode indicator %04b", mode)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// The terminator may be omitted when the data fills the symbol.
+	result.Content = string(content)
+
+	return nil
+}
+
+func parseNumericSegment(r *bitReader, charCountBits int, content []byte) ([]byte, error) {
+	n, err := r.read(charCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	for ; n > 0; n -= 3 {
+		digits, bitsUsed := 3, 10
+		if n < 3 {
+			digits, bitsUsed = int(n), 1+3*int(n)
+		}
+
+		v, err := r.read(bitsUsed)
+		if err != nil {
+			return nil, err
+		}
+
+		s := fmt.Sprintf("%0*d", digits, v)
+		if len(s) != digits {
+			return nil, errors.New("invalid numeric data")
+		}
+
+		content = append(content, s...)
+
+		if n < 3 {
+			break
+		}
+	}
+
+	return content, nil
+}
+
+func parseAlphanumericSegment(r *bitReader, charCountBits int, fnc1 bool, content []byte) ([]byte, error) {
+	n, err := r.read(charCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	var chars []byte
+	for ; n > 0; n -= 2 {
+		if n == 1 {
+			v, err := r.read(6)
+			if err != nil {
+				return nil, err
+			} else if v >= 45 {
+				return nil, errors.New("invalid alphanumeric data")
+			}
+
+			chars = append(chars, alphanumericCharacters[v])
+			break
+		}
+
+		v, err := r.read(11)
+		if err != nil {
+			return nil, err
+		} else if v >= 45*45 {
+			return nil, errors.New("invalid alphanumeric data")
+		}
+
+		chars = append(chars, alphanumericCharacters[v/45], alphanumericCharacters[v%45])
+	}
+
+	if !fnc1 {
+		return append(content, chars...), nil
+	}
+
+	// In FNC1 mode '%' stands for the group separator, and "%%" for '%'.
+	for i := 0; i < len(chars); i++ {
+		switch {
+		case chars[i] == '%' && i+1 < len(chars) && chars[i+1] == '%':
+			content = append(content, '%')
+			i++
+		case chars[i] == '%':
+			content = append(content, gs1GroupSeparator)
+		default:
+			content = append(content, chars[i])
+		}
+	}
+
+	return content, nil
+}
+
+func parseByteSegment(r *bitReader, charCountBits int, content []byte) ([]byte, error) {
+	n, err := r.read(charCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		v, err := r.read(8)
+		if err != nil {
+			return nil, err
+		}
+
+		content = append(content, byte(v))
+	}
+
+	return content, nil
+}
+
+// parseKanjiSegment decodes Kanji characters back to Shift JIS, reversing
+// encodeKanjiCharacter.
+func parseKanjiSegment(r *bitReader, charCountBits int, content []byte) ([]byte, error) {
+	n, err := r.read(charCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		v, err := r.read(13)
+		if err != nil {
+			return nil, err
+		}
+
+		c := (v/0xc0)<<8 | v%0xc0
+		if c < 0x1f00 {
+			c += 0x8140
+		} else {
+			c += 0xc140
+		}
+
+		content = append(content, byte(c>>8), byte(c))
+	}
+
+	return content, nil
+}
+
+// parseECI reads an ECI designator, see appendECI.
+func parseECI(r *bitReader) (Charset, error) {
+	v, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case v&0x80 == 0:
+		return Charset(v), nil
+	case v&0xc0 == 0x80:
+		next, err := r.read(8)
+		return Charset((v&0x3f)<<8 | next), err
+	case v&0xe0 == 0xc0:
+		next, err := r.read(16)
+		return Charset((v&0x1f)<<16 | next), err
+	}
+
+	return 0, errors.New("invalid ECI designator")
+}
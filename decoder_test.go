@@ -0,0 +1,191 @@
+package qart
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkRoundTrip decodes the bitmap of q and checks it matches what was
+// encoded.
+func checkRoundTrip(t *testing.T, q *HalftoneQRCode) *DecodedCode {
+	d, err := DecodeBitmap(q.Bitmap())
+	if err != nil {
+		t.Errorf("%q decode got %s, expected success", q.Content, err.Error())
+		return nil
+	}
+
+	if d.Content != q.Content {
+		t.Errorf("decoded %q, expected %q", d.Content, q.Content)
+	}
+
+	if d.VersionNumber != q.VersionNumber || d.Level != q.Level || d.Mask != q.mask {
+		t.Errorf("%q decoded version %d level %d mask %d, expected version %d level %d mask %d",
+			q.Content, d.VersionNumber, d.Level, d.Mask, q.VersionNumber, q.Level, q.mask)
+	}
+
+	return d
+}
+
+func TestDecodeBitmap(t *testing.T) {
+	tests := []string{
+		"0",
+		"01234567",
+		"HELLO WORLD",
+		"http://www.example.org",
+		"ABC123456789def",
+		"\x00\xff\x7f",
+		strings.Repeat("0", 7089),
+		strings.Repeat("A", 1000) + strings.Repeat("1", 1000) + strings.Repeat("a", 500),
+		// Shift JIS Kanji.
+		strings.Repeat("\x93\x5f\xe4\xaa", 10),
+	}
+
+	for _, content := range tests {
+		for _, level := range []RecoveryLevel{Low, Medium, High, Highest} {
+			q, err := NewHalftoneCode(content, level)
+			if err != nil {
+				// The longest content does not fit the higher levels.
+				continue
+			}
+
+			checkRoundTrip(t, q)
+		}
+	}
+}
+
+func TestDecodeBitmapAllVersions(t *testing.T) {
+	for version := 1; version <= 40; version++ {
+		for _, level := range []RecoveryLevel{Low, Medium, High, Highest} {
+			q, err := NewHalftoneCodeWithOption("hello", level,
+				EncodeOption{Version: version, ForceMask: true, Mask: version % 8})
+			if err != nil {
+				t.Fatalf("version %d got %s, expected success", version, err.Error())
+			}
+
+			checkRoundTrip(t, q)
+		}
+	}
+}
+
+func TestDecodeBitmapHeaders(t *testing.T) {
+	q, err := NewHalftoneCodeWithOption("Gr\xc3\xbc\xc3\x9fe", Medium, EncodeOption{Charset: CharsetUTF8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := checkRoundTrip(t, q); d != nil && d.Charset != CharsetUTF8 {
+		t.Errorf("decoded charset %d, expected %d", d.Charset, CharsetUTF8)
+	}
+
+	q, err = NewGS1HalftoneCode("(01)09501101530003(10)AB-1(17)250101", Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := checkRoundTrip(t, q); d != nil && d.FNC1 != FNC1First {
+		t.Errorf("decoded FNC1 mode %d, expected %d", d.FNC1, FNC1First)
+	}
+
+	q, err = NewHalftoneCodeWithOption("123", Medium, EncodeOption{FNC1: FNC1Second, ApplicationIndicator: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := checkRoundTrip(t, q); d != nil && (d.FNC1 != FNC1Second || d.ApplicationIndicator != "a") {
+		t.Errorf("decoded FNC1 mode %d indicator %q, expected %d %q", d.FNC1,
+			d.ApplicationIndicator, FNC1Second, "a")
+	}
+
+	s, err := NewStructuredAppend(strings.Repeat("#", 2955), Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, q := range s.Codes {
+		d := checkRoundTrip(t, q)
+		if d != nil && (d.StructuredAppendIndex != i || d.StructuredAppendTotal != len(s.Codes) ||
+			d.StructuredAppendParity != 0x23) {
+			t.Errorf("symbol %d decoded sequence %d/%d parity %#x", i, d.StructuredAppendIndex,
+				d.StructuredAppendTotal, d.StructuredAppendParity)
+		}
+	}
+}
+
+func TestDecodeBitmapFNC1GroupSeparators(t *testing.T) {
+	// Adjacent group separators must not encode as '%%', a literal '%'.
+	for _, content := range []string{"AB\x1dCD", "AB\x1d\x1dCD", "\x1d\x1d", "A\x1d\x1d\x1dB%C"} {
+		q, err := NewHalftoneCodeWithOption(content, Medium, EncodeOption{FNC1: FNC1First})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := DecodeBitmap(q.Bitmap())
+		if err != nil {
+			t.Errorf("%q got %s, expected success", content, err.Error())
+		} else if d.Content != content {
+			t.Errorf("decoded %q, expected %q", d.Content, content)
+		}
+	}
+}
+
+func TestDecodeBitmapErrorCorrection(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bitmap := q.Bitmap()
+
+	// Flip a few data modules, and a format information module.
+	damaged := make([][]bool, len(bitmap))
+	for y := range bitmap {
+		damaged[y] = append([]bool(nil), bitmap[y]...)
+	}
+
+	quietZone := q.symbol.quietZoneSize
+	for _, p := range [][2]int{{19, 19}, {20, 19}, {16, 14}, {11, 21}, {8, 1}} {
+		x, y := p[0]+quietZone, p[1]+quietZone
+		damaged[y][x] = !damaged[y][x]
+	}
+
+	d, err := DecodeBitmap(damaged)
+	if err != nil {
+		t.Fatalf("got %s, expected success", err.Error())
+	}
+
+	if d.Content != q.Content {
+		t.Errorf("decoded %q, expected %q", d.Content, q.Content)
+	}
+
+	if d.NumCorrected == 0 {
+		t.Error("no codewords corrected, expected corrections")
+	}
+
+	// Damage every data module.
+	for y := range damaged {
+		for x := range damaged[y] {
+			if q.isDataModule(x, y) {
+				damaged[y][x] = !damaged[y][x]
+			}
+		}
+	}
+
+	if _, err := DecodeBitmap(damaged); err == nil {
+		t.Error("inverted data decoded, expected error")
+	}
+}
+
+func TestDecodeBitmapInvalid(t *testing.T) {
+	tests := [][][]bool{
+		nil,
+		{{false, false}, {false, false}},
+		{{true, false}, {true}},
+		{{true}},
+	}
+
+	for _, bitmap := range tests {
+		if _, err := DecodeBitmap(bitmap); err == nil {
+			t.Errorf("%v decoded, expected error", bitmap)
+		}
+	}
+}
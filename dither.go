@@ -0,0 +1,158 @@
+package qart
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// DitherMode selects how the mask image is reduced to a binary halftone before
+// being drawn around the modules.
+type DitherMode uint8
+
+const (
+	// NoDither draws the mask image in full colour.
+	NoDither DitherMode = iota
+
+	// DitherFloydSteinberg diffuses the error of every pixel to 4 neighbours.
+	DitherFloydSteinberg
+
+	// DitherAtkinson diffuses 3/4 of the error to 6 neighbours, losing detail
+	// in the shadows and highlights but keeping it in the midtones.
+	DitherAtkinson
+
+	// DitherJarvisJudiceNinke diffuses the error to 12 neighbours, giving a
+	// smoother but coarser halftone than Floyd-Steinberg.
+	DitherJarvisJudiceNinke
+
+	// DitherBayer thresholds every pixel against an 8x8 Bayer matrix, giving
+	// a regular cross-hatched pattern.
+	DitherBayer
+)
+
+// errorDiffusion is an error diffusion kernel: the error of every pixel is
+// spread to the pixels at the given offsets, in proportion to weight/divisor.
+type errorDiffusion struct {
+	offsets []diffusionOffset
+	divisor float64
+}
+
+type diffusionOffset struct {
+	dx, dy int
+	weight float64
+}
+
+var errorDiffusions = map[DitherMode]errorDiffusion{
+	DitherFloydSteinberg: {
+		offsets: []diffusionOffset{
+			{1, 0, 7},
+			{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+		},
+		divisor: 16,
+	},
+	DitherAtkinson: {
+		offsets: []diffusionOffset{
+			{1, 0, 1}, {2, 0, 1},
+			{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+			{0, 2, 1},
+		},
+		divisor: 8,
+	},
+	DitherJarvisJudiceNinke: {
+		offsets: []diffusionOffset{
+			{1, 0, 7}, {2, 0, 5},
+			{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+			{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+		},
+		divisor: 48,
+	},
+}
+
+// bayerMatrix is the 8x8 Bayer threshold map, with values 0-63.
+var bayerMatrix = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// dither returns img reduced to black and white, or to the 8 colours with
+// every channel either 0 or 255 if colour is set. The alpha channel of img is
+// kept.
+func dither(img image.Image, mode DitherMode, colour bool) (*image.NRGBA, error) {
+	diffusion, isDiffusion := errorDiffusions[mode]
+	if !isDiffusion && mode != DitherBayer {
+		return nil, errors.New("unknown dither mode")
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	numChannels := 1
+	if colour {
+		numChannels = 3
+	}
+
+	// Channel values of every pixel, including the errors diffused so far.
+	values := make([][]float64, numChannels)
+	for c := range values {
+		values[c] = make([]float64, w*h)
+	}
+
+	ret := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			ret.Pix[ret.PixOffset(x, y)+3] = p.A
+
+			if colour {
+				values[0][y*w+x] = float64(p.R)
+				values[1][y*w+x] = float64(p.G)
+				values[2][y*w+x] = float64(p.B)
+			} else {
+				values[0][y*w+x] = float64(luminance(color.NRGBA{p.R, p.G, p.B, 0xff}))
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			threshold := 127.5
+			if mode == DitherBayer {
+				threshold = (bayerMatrix[y%8][x%8] + 0.5) * 255 / 64
+			}
+
+			i := ret.PixOffset(x, y)
+			for c, v := range values {
+				var out float64
+				if v[y*w+x] > threshold {
+					out = 255
+				}
+
+				if colour {
+					ret.Pix[i+c] = uint8(out)
+				} else {
+					ret.Pix[i], ret.Pix[i+1], ret.Pix[i+2] = uint8(out), uint8(out), uint8(out)
+				}
+
+				if !isDiffusion {
+					continue
+				}
+
+				err := v[y*w+x] - out
+				for _, o := range diffusion.offsets {
+					if nx, ny := x+o.dx, y+o.dy; nx >= 0 && nx < w && ny < h {
+						v[ny*w+nx] += err * o.weight / diffusion.divisor
+					}
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,118 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// whiteFraction returns the fraction of the pixels of img which are white, and
+// false if any pixel has a channel which is neither 0 nor 255.
+func whiteFraction(img *image.NRGBA) (float64, bool) {
+	numWhite := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		for _, v := range img.Pix[i : i+3] {
+			if v != 0 && v != 0xff {
+				return 0, false
+			}
+		}
+
+		if img.Pix[i] == 0xff && img.Pix[i+1] == 0xff && img.Pix[i+2] == 0xff {
+			numWhite++
+		}
+	}
+
+	return float64(numWhite) / float64(len(img.Pix)/4), true
+}
+
+func TestDither(t *testing.T) {
+	modes := []DitherMode{DitherFloydSteinberg, DitherAtkinson, DitherJarvisJudiceNinke, DitherBayer}
+
+	for _, mode := range modes {
+		for _, gray := range []uint8{0, 64, 128, 192, 255} {
+			src := image.NewGray(image.Rect(0, 0, 32, 32))
+			for i := range src.Pix {
+				src.Pix[i] = gray
+			}
+
+			d, err := dither(src, mode, false)
+			if err != nil {
+				t.Fatalf("mode %d got %s, expected success", mode, err.Error())
+			}
+
+			// The density of white pixels follows the gray level. Atkinson
+			// dithering does not diffuse the whole error and exaggerates
+			// contrast.
+			tolerance := 0.05
+			if mode == DitherAtkinson {
+				tolerance = 0.1
+			}
+
+			f, isBinary := whiteFraction(d)
+			if !isBinary {
+				t.Errorf("mode %d gray %d got non binary pixels", mode, gray)
+			} else if expected := float64(gray) / 255; f < expected-tolerance || f > expected+tolerance {
+				t.Errorf("mode %d gray %d got %f white, expected %f", mode, gray, f, expected)
+			}
+		}
+	}
+
+	if _, err := dither(image.NewGray(image.Rect(0, 0, 1, 1)), NoDither, false); err == nil {
+		t.Error("NoDither got success, expected error")
+	}
+}
+
+func TestDitherColor(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			src.Set(x, y, color.NRGBA{0xff, 0x80, 0, 0x40})
+		}
+	}
+
+	d, err := dither(src, DitherFloydSteinberg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numGreen := 0
+	for i := 0; i < len(d.Pix); i += 4 {
+		if d.Pix[i] != 0xff || d.Pix[i+2] != 0 || d.Pix[i+3] != 0x40 {
+			t.Fatalf("got %v, expected red kept, blue off and alpha kept", d.Pix[i:i+4])
+		}
+
+		if d.Pix[i+1] == 0xff {
+			numGreen++
+		}
+	}
+
+	if numGreen < 100 || numGreen > 156 {
+		t.Errorf("got %d green pixels, expected about half", numGreen)
+	}
+}
+
+func TestHalftoneQRCodeDither(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage()), Dither: DitherAtkinson})
+
+	img, err := q.CodeImage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rgba := img.(*image.RGBA)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if p := rgba.Pix[i : i+3]; (p[0] != 0 && p[0] != 0xff) || p[1] != p[0] || p[2] != p[0] {
+			t.Fatalf("got pixel %v, expected black or white", p)
+		}
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected success", err.Error())
+	}
+}
@@ -0,0 +1,106 @@
+package qart
+
+import (
+	"errors"
+
+	"github.com/xrlin/qart/bitset"
+)
+
+// Charset identifies the character set of the content through its ECI
+// (Extended Channel Interpretation) assignment number.
+//
+// When a Charset is declared, an ECI segment is placed in front of the data so
+// that readers do not have to guess between ISO-8859-1, UTF-8, Shift JIS etc.
+// It is left out for ASCII content in a charset extending ASCII, which readers
+// decode the same way without it.
+//
+// The content is encoded as-is: it must already be encoded in the declared
+// character set.
+type Charset uint32
+
+const (
+	// NoCharset does not declare a character set, no ECI segment is emitted.
+	NoCharset Charset = 0
+
+	CharsetISO8859_1   Charset = 3
+	CharsetISO8859_2   Charset = 4
+	CharsetISO8859_3   Charset = 5
+	CharsetISO8859_4   Charset = 6
+	CharsetISO8859_5   Charset = 7
+	CharsetISO8859_6   Charset = 8
+	CharsetISO8859_7   Charset = 9
+	CharsetISO8859_8   Charset = 10
+	CharsetISO8859_9   Charset = 11
+	CharsetISO8859_10  Charset = 12
+	CharsetISO8859_11  Charset = 13
+	CharsetISO8859_13  Charset = 15
+	CharsetISO8859_14  Charset = 16
+	CharsetISO8859_15  Charset = 17
+	CharsetISO8859_16  Charset = 18
+	CharsetShiftJIS    Charset = 20
+	CharsetWindows1250 Charset = 21
+	CharsetWindows1251 Charset = 22
+	CharsetWindows1252 Charset = 23
+	CharsetWindows1256 Charset = 24
+	CharsetUTF16BE     Charset = 25
+	CharsetUTF8        Charset = 26
+	CharsetUSASCII     Charset = 27
+	CharsetBig5        Charset = 28
+	CharsetGB18030     Charset = 29
+	CharsetEUCKR       Charset = 30
+)
+
+// maxECIAssignment is the largest ECI assignment number which can be encoded.
+const maxECIAssignment = 999999
+
+// appendECI appends an ECI segment for charset to encoded.
+//
+// The segment consists of the ECI mode indicator (0111) followed by the ECI
+// designator, which is 8, 16 or 24 bits long depending on the assignment
+// number:
+//
+//	0-127:         0bbbbbbb
+//	128-16383:     10bbbbbb bbbbbbbb
+//	16384-999999:  110bbbbb bbbbbbbb bbbbbbbb
+func appendECI(charset Charset, encoded *bitset.Bitset) error {
+	v := uint32(charset)
+
+	encoded.Append(bitset.New(b0, b1, b1, b1))
+
+	switch {
+	case v < 1<<7:
+		encoded.AppendUint32(v, 8)
+	case v < 1<<14:
+		encoded.AppendUint32(0x2<<14|v, 16)
+	case v <= maxECIAssignment:
+		encoded.AppendUint32(0x6<<21|v, 24)
+	default:
+		return errors.New("ECI assignment number out of range")
+	}
+
+	return nil
+}
+
+// extendsASCII reports whether the charset encodes the ASCII characters as
+// ASCII does: ASCII itself, UTF-8, ISO-8859-x and the Windows code pages.
+func (c Charset) extendsASCII() bool {
+	switch {
+	case c >= CharsetISO8859_1 && c <= CharsetISO8859_16:
+		return true
+	case c >= CharsetWindows1250 && c <= CharsetWindows1256:
+		return true
+	}
+
+	return c == CharsetUTF8 || c == CharsetUSASCII
+}
+
+// isASCII returns true if data only contains 7-bit ASCII bytes.
+func isASCII(data []byte) bool {
+	for _, v := range data {
+		if v > 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
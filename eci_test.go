@@ -0,0 +1,110 @@
+package qart
+
+import (
+	"testing"
+
+	"github.com/xrlin/qart/bitset"
+)
+
+func TestAppendECI(t *testing.T) {
+	tests := []struct {
+		charset  Charset
+		expected *bitset.Bitset
+	}{
+		{
+			CharsetUTF8,
+			bitset.NewFromBase2String("0111 00011010"),
+		},
+		{
+			Charset(127),
+			bitset.NewFromBase2String("0111 01111111"),
+		},
+		{
+			Charset(128),
+			bitset.NewFromBase2String("0111 10000000 10000000"),
+		},
+		{
+			Charset(16383),
+			bitset.NewFromBase2String("0111 10111111 11111111"),
+		},
+		{
+			Charset(16384),
+			bitset.NewFromBase2String("0111 11000000 01000000 00000000"),
+		},
+		{
+			Charset(999999),
+			bitset.NewFromBase2String("0111 11001111 01000010 00111111"),
+		},
+	}
+
+	for _, test := range tests {
+		encoded := bitset.New()
+
+		if err := appendECI(test.charset, encoded); err != nil {
+			t.Errorf("ECI %d got %s, expected success", test.charset, err.Error())
+		} else if !test.expected.Equals(encoded) {
+			t.Errorf("ECI %d got %s, expected %s", test.charset, encoded.String(),
+				test.expected.String())
+		}
+	}
+
+	if err := appendECI(Charset(1000000), bitset.New()); err == nil {
+		t.Error("ECI 1000000 encodable, expected error")
+	}
+}
+
+func TestEncodeWithCharset(t *testing.T) {
+	tests := []struct {
+		charset  Charset
+		data     string
+		expected *bitset.Bitset
+	}{
+		// No ECI for pure ASCII data.
+		{
+			CharsetUTF8,
+			"A",
+			bitset.NewFromBase2String("0010 000000001 001010"),
+		},
+		{
+			CharsetUTF8,
+			"\xc3\xa9",
+			bitset.NewFromBase2String("0111 00011010 0100 00000010 11000011 10101001"),
+		},
+		{
+			CharsetISO8859_1,
+			"\xe9",
+			bitset.NewFromBase2String("0111 00000011 0100 00000001 11101001"),
+		},
+		// Kanji mode is used for declared Shift JIS data.
+		{
+			CharsetShiftJIS,
+			"\x93\x5f",
+			bitset.NewFromBase2String("0111 00010100 1000 00000001 0110110011111"),
+		},
+		// UTF-16 is not ASCII even for ASCII bytes.
+		{
+			CharsetUTF16BE,
+			"\x00A",
+			bitset.NewFromBase2String("0111 00011001 0100 00000010 00000000 01000001"),
+		},
+		// Without a declared charset no ECI is emitted.
+		{
+			NoCharset,
+			"\xc3\xa9",
+			bitset.NewFromBase2String("0100 00000010 11000011 10101001"),
+		},
+	}
+
+	for _, test := range tests {
+		encoder := newDataEncoder(dataEncoderType1To9)
+		encoder.charset = test.charset
+
+		encoded, err := encoder.encode([]byte(test.data))
+		if err != nil {
+			t.Errorf("For %q got %s, expected success", test.data, err.Error())
+		} else if !test.expected.Equals(encoded) {
+			t.Errorf("For %q got %s, expected %s", test.data, encoded.String(),
+				test.expected.String())
+		}
+	}
+}
@@ -6,6 +6,7 @@ package qart
 import (
 	"errors"
 	"log"
+	"unicode/utf8"
 
 	"github.com/xrlin/qart/bitset"
 )
@@ -15,7 +16,7 @@ import (
 // The main data portion of a QR Code consists of one or more segments of data.
 // A segment consists of:
 //
-// - The segment Data Mode: numeric, alphanumeric, or byte.
+// - The segment Data Mode: numeric, alphanumeric, byte or Kanji.
 // - The length of segment in bits.
 // - Encoded data.
 //
@@ -28,16 +29,31 @@ import (
 // encoded at a higher density of 3 numbers (e.g. 123) per 10 bits.
 //
 // Some data can be represented in multiple modes. Numeric data can be
-// represented in all three modes, whereas alphanumeric data (e.g. 'A') can be
-// represented in alphanumeric and byte mode.
+// represented in numeric, alphanumeric and byte modes, whereas alphanumeric
+// data (e.g. 'A') can be represented in alphanumeric and byte mode.
+//
+// Kanji mode packs each Shift JIS double-byte character into 13 bits instead of
+// the 16 bits byte mode would use. It is only considered when the input is
+// valid Shift JIS text (and not valid UTF-8, whose multi-byte sequences can
+// look like Shift JIS double-byte characters).
 //
 // Starting a new segment (to use a different Data Mode) has a cost, the bits to
 // state the new segment Data Mode and length. To minimise each QR Code's symbol
-// size, an optimisation routine coalesces segment types where possible, to
-// reduce the encoded data length.
+// size, an optimisation routine chooses the segmentation with the shortest
+// encoded data length.
 //
-// There are several other data modes available (e.g. Kanji mode) which are not
-// implemented here.
+// An optional ECI segment declaring the character set of the data may precede
+// the data segments, see Charset. Symbols of a Structured Append sequence start
+// with a header linking them together, see StructuredAppend. GS1 and other
+// industry formatted data is marked by an FNC1 mode indicator, see FNC1Mode.
+//
+// In FNC1 mode the group separator character (0x1d) stands for FNC1. It is
+// encoded as '%' in alphanumeric segments, so a literal '%' is only encoded in
+// byte segments.
+
+// errLengthTooLong is returned when a segment has more characters than its
+// character count can represent.
+var errLengthTooLong = errors.New("length too long to be represented")
 
 // A segment encoding mode.
 type dataMode uint8
@@ -53,6 +69,10 @@ const (
 	dataModeNumeric
 	dataModeAlphanumeric
 	dataModeByte
+
+	// dataModeKanji is not part of the subset ordering above: Kanji characters
+	// can only be represented in Kanji and byte mode.
+	dataModeKanji
 )
 
 // dataModeString returns d as a short printable string.
@@ -66,6 +86,8 @@ func dataModeString(d dataMode) string {
 		return "alphanumeric"
 	case dataModeByte:
 		return "byte"
+	case dataModeKanji:
+		return "kanji"
 	}
 
 	return "unknown"
@@ -98,15 +120,30 @@ type dataEncoder struct {
 	numericModeIndicator      *bitset.Bitset
 	alphanumericModeIndicator *bitset.Bitset
 	byteModeIndicator         *bitset.Bitset
+	kanjiModeIndicator        *bitset.Bitset
 
 	// Character count lengths.
 	numNumericCharCountBits      int
 	numAlphanumericCharCountBits int
 	numByteCharCountBits         int
+	numKanjiCharCountBits        int
 
 	// The raw input data.
 	data []byte
 
+	// Structured Append header, nil if the symbol is not part of a sequence.
+	structuredAppend *structuredAppend
+
+	// Character set declared through an ECI segment, NoCharset for none.
+	charset Charset
+
+	// FNC1 mode of the data and the application indicator used by FNC1Second.
+	fnc1                 FNC1Mode
+	applicationIndicator string
+
+	// True if Kanji mode may be used for the raw input data.
+	kanji bool
+
 	// The data classified into unoptimised segments.
 	actual []segment
 
@@ -126,9 +163,11 @@ func newDataEncoder(t dataEncoderType) *dataEncoder {
 			numericModeIndicator:         bitset.New(b0, b0, b0, b1),
 			alphanumericModeIndicator:    bitset.New(b0, b0, b1, b0),
 			byteModeIndicator:            bitset.New(b0, b1, b0, b0),
+			kanjiModeIndicator:           bitset.New(b1, b0, b0, b0),
 			numNumericCharCountBits:      10,
 			numAlphanumericCharCountBits: 9,
 			numByteCharCountBits:         8,
+			numKanjiCharCountBits:        8,
 		}
 	case dataEncoderType10To26:
 		d = &dataEncoder{
@@ -137,9 +176,11 @@ func newDataEncoder(t dataEncoderType) *dataEncoder {
 			numericModeIndicator:         bitset.New(b0, b0, b0, b1),
 			alphanumericModeIndicator:    bitset.New(b0, b0, b1, b0),
 			byteModeIndicator:            bitset.New(b0, b1, b0, b0),
+			kanjiModeIndicator:           bitset.New(b1, b0, b0, b0),
 			numNumericCharCountBits:      12,
 			numAlphanumericCharCountBits: 11,
 			numByteCharCountBits:         16,
+			numKanjiCharCountBits:        10,
 		}
 	case dataEncoderType27To40:
 		d = &dataEncoder{
@@ -148,9 +189,11 @@ func newDataEncoder(t dataEncoderType) *dataEncoder {
 			numericModeIndicator:         bitset.New(b0, b0, b0, b1),
 			alphanumericModeIndicator:    bitset.New(b0, b0, b1, b0),
 			byteModeIndicator:            bitset.New(b0, b1, b0, b0),
+			kanjiModeIndicator:           bitset.New(b1, b0, b0, b0),
 			numNumericCharCountBits:      14,
 			numAlphanumericCharCountBits: 13,
 			numByteCharCountBits:         16,
+			numKanjiCharCountBits:        12,
 		}
 	default:
 		log.Panic("Unknown dataEncoderType")
@@ -162,6 +205,10 @@ func newDataEncoder(t dataEncoderType) *dataEncoder {
 // encode data as one or more segments and return the encoded data.
 //
 // The returned data does not include the terminator bit sequence.
+//
+// The encoded data starts with the Structured Append header of the symbol, if
+// any. If a character set is declared and data contains non-ASCII bytes, an
+// ECI segment follows, then the FNC1 mode indicator if any.
 func (d *dataEncoder) encode(data []byte) (*bitset.Bitset, error) {
 	d.data = data
 	d.actual = nil
@@ -171,6 +218,15 @@ func (d *dataEncoder) encode(data []byte) (*bitset.Bitset, error) {
 		return nil, errors.New("no data to encode")
 	}
 
+	switch d.charset {
+	case NoCharset:
+		d.kanji = !utf8.Valid(data) && isShiftJIS(data)
+	case CharsetShiftJIS:
+		d.kanji = isShiftJIS(data)
+	default:
+		d.kanji = false
+	}
+
 	// Classify data into unoptimised segments.
 	d.classifyDataModes()
 
@@ -180,8 +236,28 @@ func (d *dataEncoder) encode(data []byte) (*bitset.Bitset, error) {
 		return nil, err
 	}
 
-	// Encode data.
 	encoded := bitset.New()
+
+	// Link the symbol into a Structured Append sequence.
+	if d.structuredAppend != nil {
+		d.structuredAppend.appendHeader(encoded)
+	}
+
+	// Declare the character set.
+	if d.charset != NoCharset && !(d.charset.extendsASCII() && isASCII(data)) {
+		if err := appendECI(d.charset, encoded); err != nil {
+			return nil, err
+		}
+	}
+
+	// Mark the data as formatted according to an industry standard.
+	if d.fnc1 != NoFNC1 {
+		if err := appendFNC1(d.fnc1, d.applicationIndicator, encoded); err != nil {
+			return nil, err
+		}
+	}
+
+	// Encode data.
 	for _, s := range d.optimised {
 		d.encodeDataRaw(s.data, s.dataMode, encoded)
 	}
@@ -192,17 +268,25 @@ func (d *dataEncoder) encode(data []byte) (*bitset.Bitset, error) {
 // classifyDataModes classifies the raw data into unoptimised segments.
 // e.g. "123ZZ#!#!" =>
 // [numeric, 3, "123"] [alphanumeric, 2, "ZZ"] [byte, 4, "#!#!"].
+//
+// Shift JIS double-byte characters are classified as Kanji when d.kanji is
+// set.
 func (d *dataEncoder) classifyDataModes() {
 	var start int
 	mode := dataModeNone
 
-	for i, v := range d.data {
+	for i := 0; i < len(d.data); {
+		v := d.data[i]
+		width := 1
+
 		newMode := dataModeNone
 		switch {
-		case v >= 0x30 && v <= 0x39:
+		case d.kanji && isKanjiCharacter(d.data[i:]):
+			newMode = dataModeKanji
+			width = 2
+		case isNumericCharacter(v):
 			newMode = dataModeNumeric
-		case v == 0x20 || v == 0x24 || v == 0x25 || v == 0x2a || v == 0x2b || v ==
-			0x2d || v == 0x2e || v == 0x2f || v == 0x3a || (v >= 0x41 && v <= 0x5a):
+		case d.isAlphanumeric(i):
 			newMode = dataModeAlphanumeric
 		default:
 			newMode = dataModeByte
@@ -217,75 +301,180 @@ func (d *dataEncoder) classifyDataModes() {
 
 			mode = newMode
 		}
+
+		i += width
 	}
 
 	d.actual = append(d.actual, segment{dataMode: mode, data: d.data[start:len(d.data)]})
 }
 
-// optimiseDataModes optimises the list of segments to reduce the overall output
-// encoded data length.
+// optimiseDataModes splits the data into the sequence of segments with the
+// shortest encoded length.
 //
-// The algorithm coalesces adjacent segments. segments are only coalesced when
-// the Data Modes are compatible, and when the coalesced segment has a shorter
-// encoded length than separate segments.
+// The optimal segmentation is found by dynamic programming over the states
+// (number of bytes consumed, mode of the current segment). Each character is
+// either appended to the current segment or starts a new segment in another
+// mode, which costs the new segment's mode indicator and character count bits.
 //
-// Multiple segments may be coalesced. For example a string of alternating
-// alphanumeric/numeric segments ANANANANA can be optimised to just A.
+// Characters are priced in sixths of a bit (a numeric character costs 10/3
+// bits, an alphanumeric character 11/2 bits) and a segment's length is rounded
+// up to whole bits when the next segment starts. This makes the per-character
+// costs additive, so the shortest path through the states is the minimal
+// encoded length for this dataEncoderType.
+//
+// For example "ABC123456789def" is best encoded as
+// [alphanumeric, 3, "ABC"] [numeric, 9, "123456789"] [byte, 3, "def"].
 func (d *dataEncoder) optimiseDataModes() error {
-	for i := 0; i < len(d.actual); {
-		mode := d.actual[i].dataMode
-		numChars := len(d.actual[i].data)
+	modes := [...]dataMode{dataModeNumeric, dataModeAlphanumeric, dataModeByte,
+		dataModeKanji}
 
-		j := i + 1
-		for j < len(d.actual) {
-			nextNumChars := len(d.actual[j].data)
-			nextMode := d.actual[j].dataMode
+	// Cost of one character in each mode, in sixths of a bit.
+	charCost := [...]int{20, 33, 48, 78}
 
-			if nextMode > mode {
-				break
-			}
+	// Cost of a segment header in each mode, in sixths of a bit.
+	var headerCost [len(modes)]int
+	for k, m := range modes {
+		headerCost[k] = 6 * (d.modeIndicator(m).Len() + d.charCountBits(m))
+	}
+
+	type state struct {
+		cost int
 
-			coalescedLength, err := d.encodedLength(mode, numChars+nextNumChars)
+		// Mode index and byte position of the previous state, -1 for the start.
+		prevMode int
+		prevPos  int
+	}
 
-			if err != nil {
-				return err
+	n := len(d.data)
+	states := make([][len(modes)]state, n+1)
+	for i := range states {
+		for k := range modes {
+			states[i][k].cost = -1
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		// Cheapest way to have encoded d.data[:i] with all segments closed.
+		closed, closedMode := 0, -1
+		if i > 0 {
+			closed = -1
+			for k := range modes {
+				if c := states[i][k].cost; c >= 0 {
+					c = (c + 5) / 6 * 6
+					if closed < 0 || c < closed {
+						closed, closedMode = c, k
+					}
+				}
 			}
+		}
 
-			seperateLength1, err := d.encodedLength(mode, numChars)
+		for k, m := range modes {
+			if !d.canEncode(m, i) {
+				continue
+			}
 
-			if err != nil {
-				return err
+			width := 1
+			if m == dataModeKanji {
+				width = 2
 			}
 
-			seperateLength2, err := d.encodedLength(nextMode, nextNumChars)
+			next := &states[i+width][k]
 
-			if err != nil {
-				return err
+			// Continue the current segment.
+			if c := states[i][k].cost; c >= 0 {
+				c += charCost[k]
+				if next.cost < 0 || c < next.cost {
+					*next = state{cost: c, prevMode: k, prevPos: i}
+				}
 			}
 
-			if coalescedLength < seperateLength1+seperateLength2 {
-				j++
-				numChars += nextNumChars
-			} else {
-				break
+			// Start a new segment.
+			if closed >= 0 && closedMode != k {
+				c := closed + headerCost[k] + charCost[k]
+				if next.cost < 0 || c < next.cost {
+					*next = state{cost: c, prevMode: closedMode, prevPos: i}
+				}
 			}
 		}
+	}
 
-		optimised := segment{dataMode: mode,
-			data: make([]byte, 0, numChars)}
+	best := -1
+	for k := range modes {
+		if c := states[n][k].cost; c >= 0 && (best < 0 || c < states[n][best].cost) {
+			best = k
+		}
+	}
+
+	if best < 0 {
+		return errors.New("data cannot be encoded")
+	}
+
+	// Walk back through the states to recover the segments.
+	var optimised []segment
+	end := n
+	for pos, k := n, best; pos > 0; {
+		st := states[pos][k]
 
-		for k := i; k < j; k++ {
-			optimised.data = append(optimised.data, d.actual[k].data...)
+		if st.prevMode != k {
+			optimised = append(optimised, segment{dataMode: modes[k], data: d.data[st.prevPos:end]})
+			end = st.prevPos
 		}
 
-		d.optimised = append(d.optimised, optimised)
+		pos, k = st.prevPos, st.prevMode
+	}
+
+	for i := len(optimised) - 1; i >= 0; i-- {
+		s := optimised[i]
+
+		if _, err := d.encodedLength(s.dataMode, numCharacters(s.dataMode, s.data)); err != nil {
+			return err
+		}
 
-		i = j
+		d.optimised = append(d.optimised, s)
 	}
 
 	return nil
 }
 
+// canEncode returns true if the character starting at d.data[i] can be
+// represented in dataMode.
+func (d *dataEncoder) canEncode(dataMode dataMode, i int) bool {
+	v := d.data[i]
+
+	switch dataMode {
+	case dataModeNumeric:
+		return isNumericCharacter(v)
+	case dataModeAlphanumeric:
+		return d.isAlphanumeric(i)
+	case dataModeByte:
+		return true
+	case dataModeKanji:
+		return d.kanji && isKanjiCharacter(d.data[i:])
+	}
+
+	return false
+}
+
+// isAlphanumeric returns true if d.data[i] can be represented in an
+// alphanumeric segment of the data.
+//
+// In FNC1 mode the group separator is encoded as '%', so a literal '%' cannot
+// be represented. Nor can a group separator following another, as '%%' is a
+// literal '%'.
+func (d *dataEncoder) isAlphanumeric(i int) bool {
+	v := d.data[i]
+
+	if d.fnc1 != NoFNC1 {
+		if v == gs1GroupSeparator {
+			return i == 0 || d.data[i-1] != gs1GroupSeparator
+		}
+
+		return v != '%' && isAlphanumericCharacter(v)
+	}
+
+	return isAlphanumericCharacter(v)
+}
+
 // encodeDataRaw encodes data in dataMode. The encoded data is appended to
 // encoded.
 func (d *dataEncoder) encodeDataRaw(data []byte, dataMode dataMode, encoded *bitset.Bitset) {
@@ -296,7 +485,7 @@ func (d *dataEncoder) encodeDataRaw(data []byte, dataMode dataMode, encoded *bit
 	encoded.Append(modeIndicator)
 
 	// Append character count.
-	encoded.AppendUint32(uint32(len(data)), charCountBits)
+	encoded.AppendUint32(uint32(numCharacters(dataMode, data)), charCountBits)
 
 	// Append data.
 	switch dataMode {
@@ -320,8 +509,13 @@ func (d *dataEncoder) encodeDataRaw(data []byte, dataMode dataMode, encoded *bit
 
 			var value uint32
 			for j := 0; j < charsRemaining && j < 2; j++ {
+				c := data[i+j]
+				if c == gs1GroupSeparator && d.fnc1 != NoFNC1 {
+					c = '%'
+				}
+
 				value *= 45
-				value += encodeAlphanumericCharacter(data[i+j])
+				value += encodeAlphanumericCharacter(c)
 			}
 
 			bitsUsed := 6
@@ -335,6 +529,10 @@ func (d *dataEncoder) encodeDataRaw(data []byte, dataMode dataMode, encoded *bit
 		for _, b := range data {
 			encoded.AppendByte(b, 8)
 		}
+	case dataModeKanji:
+		for i := 0; i+1 < len(data); i += 2 {
+			encoded.AppendUint32(encodeKanjiCharacter(data[i], data[i+1]), 13)
+		}
 	}
 }
 
@@ -347,6 +545,8 @@ func (d *dataEncoder) modeIndicator(dataMode dataMode) *bitset.Bitset {
 		return d.alphanumericModeIndicator
 	case dataModeByte:
 		return d.byteModeIndicator
+	case dataModeKanji:
+		return d.kanjiModeIndicator
 	default:
 		log.Panic("Unknown data mode")
 	}
@@ -364,6 +564,8 @@ func (d *dataEncoder) charCountBits(dataMode dataMode) int {
 		return d.numAlphanumericCharCountBits
 	case dataModeByte:
 		return d.numByteCharCountBits
+	case dataModeKanji:
+		return d.numKanjiCharCountBits
 	default:
 		log.Panic("Unknown data mode")
 	}
@@ -393,7 +595,7 @@ func (d *dataEncoder) encodedLength(dataMode dataMode, n int) (int, error) {
 	maxLength := (1 << uint8(charCountBits)) - 1
 
 	if n > maxLength {
-		return 0, errors.New("length too long to be represented")
+		return 0, errLengthTooLong
 	}
 
 	length := modeIndicator.Len() + charCountBits
@@ -410,11 +612,37 @@ func (d *dataEncoder) encodedLength(dataMode dataMode, n int) (int, error) {
 		length += 6 * (n % 2)
 	case dataModeByte:
 		length += 8 * n
+	case dataModeKanji:
+		length += 13 * n
 	}
 
 	return length, nil
 }
 
+// numCharacters returns the number of characters data represents in dataMode.
+//
+// Each Kanji character occupies two bytes, every other mode uses one byte per
+// character.
+func numCharacters(dataMode dataMode, data []byte) int {
+	if dataMode == dataModeKanji {
+		return len(data) / 2
+	}
+
+	return len(data)
+}
+
+// isNumericCharacter returns true if v is a QR Code numeric character: 0-9.
+func isNumericCharacter(v byte) bool {
+	return v >= 0x30 && v <= 0x39
+}
+
+// isAlphanumericCharacter returns true if v is a QR Code alphanumeric
+// character: 0-9, A-Z, SP, $%*+-./ or :.
+func isAlphanumericCharacter(v byte) bool {
+	return isNumericCharacter(v) || v == 0x20 || v == 0x24 || v == 0x25 || v == 0x2a ||
+		v == 0x2b || v == 0x2d || v == 0x2e || v == 0x2f || v == 0x3a || (v >= 0x41 && v <= 0x5a)
+}
+
 // encodeAlphanumericChar returns the QR Code encoded value of v.
 //
 // v must be a QR Code defined alphanumeric character: 0-9, A-Z, SP, $%*+-./ or
@@ -453,3 +681,64 @@ func encodeAlphanumericCharacter(v byte) uint32 {
 
 	return 0
 }
+
+// isKanjiCharacter returns true if data starts with a Shift JIS double-byte
+// character which can be represented in Kanji mode.
+//
+// Kanji mode covers the Shift JIS ranges 0x8140-0x9ffc and 0xe040-0xebbf.
+func isKanjiCharacter(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+
+	c := uint32(data[0])<<8 | uint32(data[1])
+	if (c < 0x8140 || c > 0x9ffc) && (c < 0xe040 || c > 0xebbf) {
+		return false
+	}
+
+	return data[1] >= 0x40 && data[1] <= 0xfc && data[1] != 0x7f
+}
+
+// isShiftJIS returns true if data is a valid Shift JIS byte sequence.
+func isShiftJIS(data []byte) bool {
+	for i := 0; i < len(data); i++ {
+		v := data[i]
+
+		switch {
+		case v <= 0x7f, v >= 0xa1 && v <= 0xdf:
+			// Single byte ASCII/JIS X 0201 character.
+		case v >= 0x81 && v <= 0x9f, v >= 0xe0 && v <= 0xfc:
+			if i+1 >= len(data) {
+				return false
+			}
+
+			i++
+			if next := data[i]; next < 0x40 || next > 0xfc || next == 0x7f {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// encodeKanjiCharacter returns the 13-bit QR Code encoded value of the Shift JIS
+// character (hi, lo).
+//
+// The character must be within the Kanji mode ranges, see isKanjiCharacter.
+func encodeKanjiCharacter(hi, lo byte) uint32 {
+	c := uint32(hi)<<8 | uint32(lo)
+
+	switch {
+	case c >= 0x8140 && c <= 0x9ffc:
+		c -= 0x8140
+	case c >= 0xe040 && c <= 0xebbf:
+		c -= 0xc140
+	default:
+		log.Panicf("encodeKanjiCharacter() with non Kanji char %#04x.", c)
+	}
+
+	return (c>>8)*0xc0 + c&0xff
+}
@@ -5,6 +5,7 @@ package qart
 
 import (
 	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
 
@@ -49,6 +50,30 @@ func TestClassifyDataMode(t *testing.T) {
 				},
 			},
 		},
+		// Shift JIS "点茗" followed by "A".
+		{
+			[]byte{0x93, 0x5f, 0xe4, 0xaa, 0x41},
+			[]segment{
+				{
+					dataModeKanji,
+					[]byte{0x93, 0x5f, 0xe4, 0xaa},
+				},
+				{
+					dataModeAlphanumeric,
+					[]byte{0x41},
+				},
+			},
+		},
+		// UTF-8 "中" looks like a Shift JIS Kanji character followed by a byte.
+		{
+			[]byte("中"),
+			[]segment{
+				{
+					dataModeByte,
+					[]byte("中"),
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -67,7 +92,44 @@ func TestByteModeLengthCalculations(t *testing.T) {
 		dataMode        dataMode
 		numSymbols      int
 		expectedLength  int
-	}{}
+	}{
+		{
+			dataEncoderType1To9,
+			dataModeByte,
+			3,
+			36,
+		},
+		{
+			dataEncoderType1To9,
+			dataModeByte,
+			256,
+			-1,
+		},
+		{
+			dataEncoderType1To9,
+			dataModeKanji,
+			2,
+			38,
+		},
+		{
+			dataEncoderType10To26,
+			dataModeKanji,
+			2,
+			40,
+		},
+		{
+			dataEncoderType27To40,
+			dataModeKanji,
+			2,
+			42,
+		},
+		{
+			dataEncoderType1To9,
+			dataModeKanji,
+			256,
+			-1,
+		},
+	}
 
 	for i, test := range tests {
 		encoder := newDataEncoder(test.dataEncoderType)
@@ -123,6 +185,19 @@ func TestSingleModeEncodings(t *testing.T) {
 			"123",
 			bitset.NewFromBase2String("0100 00000000 00000011 00110001 00110010 00110011"),
 		},
+		// ISO/IEC 18004 Kanji mode example: Shift JIS "点茗".
+		{
+			dataEncoderType1To9,
+			dataModeKanji,
+			"\x93\x5f\xe4\xaa",
+			bitset.NewFromBase2String("1000 00000010 0110110011111 1101010101010"),
+		},
+		{
+			dataEncoderType10To26,
+			dataModeKanji,
+			"\x93\x5f\xe4\xaa",
+			bitset.NewFromBase2String("1000 0000000010 0110110011111 1101010101010"),
+		},
 	}
 
 	for _, test := range tests {
@@ -185,8 +260,7 @@ func TestOptimiseEncoding(t *testing.T) {
 				{dataModeNumeric, 1},
 			},
 			[]testModeSegment{
-				{dataModeAlphanumeric, 1},
-				{dataModeByte, 2},
+				{dataModeByte, 3}, // length = 4 + 8 + 24 = 36.
 			},
 		},
 		// https://www.google.com/123
@@ -204,8 +278,8 @@ func TestOptimiseEncoding(t *testing.T) {
 				{dataModeNumeric, 3},
 			},
 			[]testModeSegment{
-				{dataModeByte, 23},
-				{dataModeNumeric, 3},
+				{dataModeByte, 18},        // length = 4 + 8 + 144 = 156.
+				{dataModeAlphanumeric, 8}, // length = 4 + 9 + 44 = 57.
 			},
 		},
 		// HTTPS://WWW.GOOGLE.COM/123
@@ -293,6 +367,195 @@ func TestOptimiseEncoding(t *testing.T) {
 	}
 }
 
+func TestOptimiseEncodingAgainstGreedy(t *testing.T) {
+	tests := []struct {
+		dataEncoderType dataEncoderType
+		data            string
+
+		// Encoded length in bits of the optimal segmentation.
+		expectedLength int
+
+		// Encoded length in bits of the segmentation produced by greedily
+		// coalescing adjacent segments.
+		greedyLength int
+	}{
+		{dataEncoderType1To9, "0", 18, 18},
+		{dataEncoderType1To9, "A", 19, 19},
+		{dataEncoderType1To9, "a", 20, 20},
+		{dataEncoderType1To9, "Ab1", 36, 47},
+		{dataEncoderType1To9, "ABC123456789def", 110, 110},
+		{dataEncoderType1To9, "ABC123456789DEF", 96, 104},
+		{dataEncoderType1To9, "123456ABCDEF123456", 112, 113},
+		{dataEncoderType1To9, "a1b2c3d4", 76, 76},
+		{dataEncoderType1To9, "https://www.google.com/123", 220, 220},
+		{dataEncoderType1To9, "HTTPS://WWW.GOOGLE.COM/123", 156, 156},
+		{dataEncoderType1To9, "0123456789ABCDEFGHIJ0123456789", 164, 164},
+		{dataEncoderType1To9, "A1A1A1A1A1A1A1A1A1A1", 123, 123},
+		{dataEncoderType1To9, "#1#1#1#1#1", 92, 92},
+		{dataEncoderType1To9, "abc1234567890123def", 130, 130},
+		{dataEncoderType1To9, "x12345678901234567890ABC", 131, 131},
+		{dataEncoderType1To9, "\x93\x5f\xe4\xaaA", 52, 57},
+		{dataEncoderType1To9, "\x93\x5fa\xe4\xaa", 52, 70},
+		{dataEncoderType1To9, "\x93\x5f\xe4\xaa\x93\x5f1234567", 89, 89},
+		{dataEncoderType10To26, "ABC123456789DEF", 98, 110},
+		{dataEncoderType10To26, "123456ABCDEF123456", 114, 117},
+		{dataEncoderType10To26, "abc1234567890123def", 148, 148},
+		{dataEncoderType27To40, "ABC123456789DEF", 100, 116},
+		{dataEncoderType27To40, "123456ABCDEF123456", 116, 121},
+		{dataEncoderType27To40, "abc1234567890123def", 150, 150},
+	}
+
+	for _, test := range tests {
+		encoder := newDataEncoder(test.dataEncoderType)
+
+		encoded, err := encoder.encode([]byte(test.data))
+		if err != nil {
+			t.Errorf("%q got %s, expected valid encoding", test.data, err.Error())
+			continue
+		}
+
+		greedy, err := greedyOptimiseDataModes(encoder)
+		if err != nil {
+			t.Errorf("%q greedy got %s, expected valid encoding", test.data, err.Error())
+			continue
+		}
+
+		greedyLength := 0
+		for _, s := range greedy {
+			length, _ := encoder.encodedLength(s.dataMode, numCharacters(s.dataMode, s.data))
+			greedyLength += length
+		}
+
+		if encoded.Len() != test.expectedLength {
+			t.Errorf("%q encoded as %s (%d bits), expected %d bits", test.data,
+				segmentsString(encoder.optimised), encoded.Len(), test.expectedLength)
+		}
+
+		if greedyLength != test.greedyLength {
+			t.Errorf("%q greedy encoded as %s (%d bits), expected %d bits", test.data,
+				segmentsString(greedy), greedyLength, test.greedyLength)
+		}
+
+		if encoded.Len() > greedyLength {
+			t.Errorf("%q encoded in %d bits, greedy segmentation uses %d bits",
+				test.data, encoded.Len(), greedyLength)
+		}
+	}
+}
+
+func TestOptimiseEncodingIsMinimal(t *testing.T) {
+	alphabet := []string{"1", "A", "#", "\x93\x5f"}
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 300; n++ {
+		var data []byte
+		for i := 0; i < 1+r.Intn(10); i++ {
+			data = append(data, alphabet[r.Intn(len(alphabet))]...)
+		}
+
+		for _, dataEncoderType := range []dataEncoderType{dataEncoderType1To9,
+			dataEncoderType10To26, dataEncoderType27To40} {
+			encoder := newDataEncoder(dataEncoderType)
+
+			encoded, err := encoder.encode(data)
+			if err != nil {
+				t.Fatalf("%q got %s, expected valid encoding", data, err.Error())
+			}
+
+			if expected := bruteForceEncodedLength(encoder, 0); encoded.Len() != expected {
+				t.Errorf("%q encoded as %s (%d bits), expected %d bits", data,
+					segmentsString(encoder.optimised), encoded.Len(), expected)
+			}
+		}
+	}
+}
+
+// bruteForceEncodedLength returns the shortest encoded length of d.data[start:]
+// by trying every possible segmentation.
+func bruteForceEncodedLength(d *dataEncoder, start int) int {
+	if start == len(d.data) {
+		return 0
+	}
+
+	best := -1
+	for _, mode := range []dataMode{dataModeNumeric, dataModeAlphanumeric,
+		dataModeByte, dataModeKanji} {
+		width := 1
+		if mode == dataModeKanji {
+			width = 2
+		}
+
+		for end := start; end < len(d.data) && d.canEncode(mode, end); {
+			end += width
+
+			length, _ := d.encodedLength(mode, numCharacters(mode, d.data[start:end]))
+			length += bruteForceEncodedLength(d, end)
+
+			if best < 0 || length < best {
+				best = length
+			}
+		}
+	}
+
+	return best
+}
+
+// greedyOptimiseDataModes coalesces the classified segments of d the way the
+// previous segment optimiser did: adjacent segments are only coalesced when the
+// Data Modes are compatible, and when the coalesced segment has a shorter
+// encoded length than separate segments.
+func greedyOptimiseDataModes(d *dataEncoder) ([]segment, error) {
+	var result []segment
+
+	for i := 0; i < len(d.actual); {
+		mode := d.actual[i].dataMode
+		numChars := len(d.actual[i].data)
+
+		j := i + 1
+		for j < len(d.actual) {
+			nextNumChars := len(d.actual[j].data)
+			nextMode := d.actual[j].dataMode
+
+			if nextMode > mode || mode == dataModeKanji {
+				break
+			}
+
+			coalescedLength, err := d.encodedLength(mode, numChars+nextNumChars)
+			if err != nil {
+				return nil, err
+			}
+
+			seperateLength1, err := d.encodedLength(mode, numChars)
+			if err != nil {
+				return nil, err
+			}
+
+			seperateLength2, err := d.encodedLength(nextMode, nextNumChars)
+			if err != nil {
+				return nil, err
+			}
+
+			if coalescedLength < seperateLength1+seperateLength2 {
+				j++
+				numChars += nextNumChars
+			} else {
+				break
+			}
+		}
+
+		coalesced := segment{dataMode: mode, data: make([]byte, 0, numChars)}
+		for k := i; k < j; k++ {
+			coalesced.data = append(coalesced.data, d.actual[k].data...)
+		}
+
+		result = append(result, coalesced)
+
+		i = j
+	}
+
+	return result, nil
+}
+
 func testModeSegmentsString(segments []testModeSegment) string {
 	result := "["
 
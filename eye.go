@@ -0,0 +1,90 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/vector"
+)
+
+// EyeStyle sets how the finder patterns, the eyes in the corners of the code,
+// and the alignment patterns are drawn, see Option.Eyes.
+//
+// Every pattern is drawn solid as a whole: its outer ring, the light ring
+// inside it and its center, each in the shape set.
+type EyeStyle struct {
+	// Shape of the rings and the center, e.g. RoundedShape for rounded eyes or
+	// CircleShape for circular eyes. Square if nil.
+	Shape ModuleShape
+
+	// Colours of the outer ring and the center of the finder patterns, and of
+	// the alignment patterns. ForegroundColor if nil.
+	OuterColor     color.Color
+	InnerColor     color.Color
+	AlignmentColor color.Color
+}
+
+// drawEyes draws the finder and alignment patterns of the code in the
+// rectangle codeRect of img, in the eye style.
+func (q *HalftoneQRCode) drawEyes(img draw.Image, codeRect image.Rectangle, moduleWidth int) {
+	for _, r := range q.symbol.finderPatterns {
+		q.drawEye(img, codeRect, moduleWidth, r, q.eyeColor(q.option.Eyes.OuterColor),
+			q.eyeColor(q.option.Eyes.InnerColor))
+	}
+
+	for _, r := range q.symbol.alignmentPatterns {
+		q.drawAlignmentPattern(img, codeRect, moduleWidth, r)
+	}
+}
+
+// drawAlignmentPattern draws the alignment pattern covering the modules of r in
+// the rectangle codeRect of img, in the eye style if set.
+func (q *HalftoneQRCode) drawAlignmentPattern(img draw.Image, codeRect image.Rectangle, moduleWidth int,
+	r image.Rectangle) {
+	var c color.Color
+	if q.option.Eyes != nil {
+		c = q.option.Eyes.AlignmentColor
+	}
+
+	q.drawEye(img, codeRect, moduleWidth, r, q.eyeColor(c), q.eyeColor(c))
+}
+
+// eyeColor returns c, or ForegroundColor if nil.
+func (q *HalftoneQRCode) eyeColor(c color.Color) color.Color {
+	if c == nil {
+		return q.option.ForegroundColor
+	}
+	return c
+}
+
+// drawEye draws a pattern covering the modules of r in the rectangle codeRect
+// of img: the dark outer ring and center, and the light ring between them, in
+// the eye shape.
+func (q *HalftoneQRCode) drawEye(img draw.Image, codeRect image.Rectangle, moduleWidth int, r image.Rectangle,
+	outer color.Color, inner color.Color) {
+	var shape ModuleShape
+	if q.option.Eyes != nil {
+		shape = q.option.Eyes.Shape
+	}
+	if shape == nil {
+		shape = SquareShape
+	}
+
+	light := q.lightColor(r.Min.X, r.Min.Y)
+
+	r = image.Rect(r.Min.X*moduleWidth, r.Min.Y*moduleWidth, r.Max.X*moduleWidth, r.Max.Y*moduleWidth).
+		Add(codeRect.Min)
+
+	draw.Draw(img, r, image.NewUniform(light), image.Point{}, draw.Src)
+
+	z := vector.NewRasterizer(0, 0)
+	for i, c := range []color.Color{outer, light, inner} {
+		ring := r.Inset(i * moduleWidth)
+		if ring.Empty() {
+			break
+		}
+
+		drawModuleShape(img, z, ring, c, shape, Neighbours{})
+	}
+}
@@ -0,0 +1,80 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEyeStyle(t *testing.T) {
+	const moduleWidth = 9
+
+	red := color.RGBA{0xc0, 0, 0, 0xff}
+	green := color.RGBA{0, 0x80, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+
+	// Version 2 has a single alignment pattern.
+	q, err := NewHalftoneCodeWithOption("hello", Highest, EncodeOption{Version: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.symbol.finderPatterns) != 3 || len(q.symbol.alignmentPatterns) != 1 {
+		t.Fatalf("got %d finder %d alignment patterns, expected 3 and 1", len(q.symbol.finderPatterns),
+			len(q.symbol.alignmentPatterns))
+	}
+
+	tests := []struct {
+		shape ModuleShape
+
+		// Expected colour of the corner of the finder patterns.
+		corner color.Color
+	}{
+		{nil, red},
+		{RoundedShape, color.White},
+		{CircleShape, color.White},
+	}
+
+	for i, test := range tests {
+		q.AddOption(Option{Eyes: &EyeStyle{Shape: test.shape, OuterColor: red, InnerColor: blue, AlignmentColor: green}})
+
+		img, err := q.CodeImage(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Colour at the center of the module at x, y of the bitmap, plus dx, dy
+		// pixels.
+		at := func(p image.Point, dx, dy int) color.RGBA {
+			return color.RGBAModel.Convert(img.At(p.X*moduleWidth+moduleWidth/2+dx,
+				p.Y*moduleWidth+moduleWidth/2+dy)).(color.RGBA)
+		}
+
+		for _, r := range q.symbol.finderPatterns {
+			center := r.Min.Add(image.Pt(3, 3))
+
+			for _, pixel := range []struct {
+				name     string
+				c        color.RGBA
+				expected color.Color
+			}{
+				{"center", at(center, 0, 0), blue},
+				{"outer ring", at(image.Pt(center.X, r.Min.Y), 0, 0), red},
+				{"inner ring", at(image.Pt(center.X, r.Min.Y+1), 0, 0), color.White},
+				{"corner", at(r.Min, -moduleWidth/2, -moduleWidth/2), test.corner},
+			} {
+				if pixel.c != color.RGBAModel.Convert(pixel.expected) {
+					t.Errorf("shape %d: got %s %v, expected %v", i, pixel.name, pixel.c, pixel.expected)
+				}
+			}
+		}
+
+		center := q.symbol.alignmentPatterns[0].Min.Add(image.Pt(2, 2))
+		if c := at(center, 0, 0); c != green {
+			t.Errorf("shape %d: got alignment center %v, expected %v", i, c, green)
+		}
+		if c := at(center.Add(image.Pt(0, -1)), 0, 0); c != color.RGBAModel.Convert(color.White) {
+			t.Errorf("shape %d: got alignment light ring %v, expected white", i, c)
+		}
+	}
+}
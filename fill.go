@@ -0,0 +1,293 @@
+package qart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// defaultMinContrast is the contrast ratio required between the dark modules
+// and BackgroundColor, unless set by Option.MinContrast. It is the WCAG AA
+// contrast of text.
+const defaultMinContrast = 4.5
+
+// Fill sets the colour of every dark module, see Option.ForegroundFill.
+type Fill interface {
+	// ColorAt returns the colour of the dark module at p, or nil for
+	// ForegroundColor.
+	ColorAt(p FillPoint) color.Color
+}
+
+// FillPoint is a dark module filled by a Fill.
+type FillPoint struct {
+	// Position of the module center, in modules from the top left corner of
+	// the symbol, quiet zone excluded.
+	X, Y float64
+
+	// Width of the symbol in modules, quiet zone excluded.
+	Size int
+
+	// Colour of the mask image around the module, nil without mask image.
+	MaskColor color.Color
+}
+
+// SolidFill fills the modules with a single colour.
+type SolidFill struct {
+	Color color.Color
+}
+
+func (f SolidFill) ColorAt(p FillPoint) color.Color {
+	return f.Color
+}
+
+// LinearGradient fills the modules with a gradient from the colour From to the
+// colour To across the symbol. Angle sets the direction of the gradient in
+// degrees, clockwise: 0 from left to right, 90 from top to bottom.
+type LinearGradient struct {
+	From, To color.Color
+	Angle    float64
+}
+
+func (f LinearGradient) ColorAt(p FillPoint) color.Color {
+	sin, cos := math.Sincos(f.Angle * math.Pi / 180)
+
+	// Project the point on the direction, from -0.5 to 0.5 between the
+	// corners of the symbol.
+	u, v := p.X/float64(p.Size)-0.5, p.Y/float64(p.Size)-0.5
+	t := 0.5 + (u*cos+v*sin)/(math.Abs(cos)+math.Abs(sin))
+
+	return lerpColor(f.From, f.To, t)
+}
+
+// RadialGradient fills the modules with a gradient from the colour Center at
+// the center of the symbol to the colour Edge at its corners.
+type RadialGradient struct {
+	Center, Edge color.Color
+}
+
+func (f RadialGradient) ColorAt(p FillPoint) color.Color {
+	u, v := p.X/float64(p.Size)-0.5, p.Y/float64(p.Size)-0.5
+
+	return lerpColor(f.Center, f.Edge, math.Hypot(u, v)*math.Sqrt2)
+}
+
+// MaskImageFill fills the modules with the colour of the mask image around
+// them, darkened as much as needed for the contrast with BackgroundColor.
+// ForegroundColor fills them without mask image.
+type MaskImageFill struct{}
+
+func (MaskImageFill) ColorAt(p FillPoint) color.Color {
+	return p.MaskColor
+}
+
+// RegionFill fills the modules of every region with its own fill, a palette
+// for example, and the modules out of every region with Default, or
+// ForegroundColor if nil.
+type RegionFill struct {
+	Regions []FillRegion
+	Default Fill
+}
+
+// FillRegion is a region of a RegionFill. Rect is in modules of the symbol,
+// quiet zone excluded. The first region holding a module fills it.
+type FillRegion struct {
+	Rect image.Rectangle
+	Fill Fill
+}
+
+func (f RegionFill) ColorAt(p FillPoint) color.Color {
+	module := image.Pt(int(math.Floor(p.X)), int(math.Floor(p.Y)))
+
+	for _, r := range f.Regions {
+		if module.In(r.Rect) {
+			return r.Fill.ColorAt(p)
+		}
+	}
+
+	if f.Default == nil {
+		return nil
+	}
+	return f.Default.ColorAt(p)
+}
+
+// ContrastError is returned when the colour of dark modules is not dark enough
+// against BackgroundColor for the code to scan.
+type ContrastError struct {
+	// Colour of the dark modules.
+	Color color.Color
+
+	// Contrast ratio of the colour, and the ratio required.
+	Ratio       float64
+	MinContrast float64
+}
+
+func (e *ContrastError) Error() string {
+	return fmt.Sprintf("contrast ratio %.2f of dark modules %v below %g", e.Ratio, e.Color, e.MinContrast)
+}
+
+// moduleColors returns the colour of every dark module of the bitmap filled by
+// the ForegroundFill option, or nil without fill. The colours of a MaskImageFill
+// are darkened to the contrast required, other colours are refused with a
+// *ContrastError.
+func (q *HalftoneQRCode) moduleColors(sourceImage image.Image) ([][]color.Color, error) {
+	fill := q.option.ForegroundFill
+	if fill == nil {
+		return nil, nil
+	}
+
+	minContrast := q.minContrast()
+	size := q.symbol.size
+	quietZone := q.symbol.quietZoneSize
+
+	// The mask image reduced to a pixel per module.
+	small, err := q.getMaskAreaImage(sourceImage, image.Rect(0, 0, size, size))
+	if err != nil {
+		return nil, err
+	}
+
+	_, darken := fill.(MaskImageFill)
+
+	colors := make([][]color.Color, size)
+	for y, row := range q.symbol.bitmap() {
+		colors[y] = make([]color.Color, size)
+
+		for x, v := range row {
+			if !v {
+				continue
+			}
+
+			p := FillPoint{
+				X:    float64(x-quietZone) + 0.5,
+				Y:    float64(y-quietZone) + 0.5,
+				Size: q.symbol.symbolSize,
+			}
+			if small != nil {
+				p.MaskColor = small.At(x, y)
+			}
+
+			c := fill.ColorAt(p)
+			if c == nil {
+				c = q.option.ForegroundColor
+			}
+
+			if darken {
+				c = darkenColor(c, q.option.BackgroundColor, minContrast)
+			}
+
+			if err := q.checkContrast(c); err != nil {
+				return nil, err
+			}
+
+			colors[y][x] = c
+		}
+	}
+
+	return colors, nil
+}
+
+// darkColors returns the colours of the dark modules other than those of
+// ForegroundFill: ForegroundColor and the eye colours set.
+func (q *HalftoneQRCode) darkColors() []color.Color {
+	colors := []color.Color{q.option.ForegroundColor}
+
+	if eyes := q.option.Eyes; eyes != nil {
+		for _, c := range []color.Color{eyes.OuterColor, eyes.InnerColor, eyes.AlignmentColor} {
+			if c != nil {
+				colors = append(colors, c)
+			}
+		}
+	}
+
+	return colors
+}
+
+// checkContrast returns a *ContrastError if one of the dark colours is not
+// dark enough against BackgroundColor.
+func (q *HalftoneQRCode) checkContrast(dark ...color.Color) error {
+	minContrast := q.minContrast()
+
+	for _, c := range dark {
+		if ratio := contrastRatio(q.option.BackgroundColor, c); ratio < minContrast {
+			return &ContrastError{Color: c, Ratio: ratio, MinContrast: minContrast}
+		}
+	}
+
+	return nil
+}
+
+func (q *HalftoneQRCode) minContrast() float64 {
+	if q.option.MinContrast == 0 {
+		return defaultMinContrast
+	}
+	return q.option.MinContrast
+}
+
+// relativeLuminance returns the relative luminance of c, from 0 for black to 1
+// for white, as defined by WCAG.
+func relativeLuminance(c color.Color) float64 {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+
+	linear := func(v uint8) float64 {
+		s := float64(v) / 0xff
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+
+	return 0.2126*linear(n.R) + 0.7152*linear(n.G) + 0.0722*linear(n.B)
+}
+
+// contrastRatio returns the contrast ratio, from 1 to 21, of the dark colour
+// over the light colour. It is below 1 if dark is the lighter.
+func contrastRatio(light color.Color, dark color.Color) float64 {
+	return (relativeLuminance(light) + 0.05) / (relativeLuminance(dark) + 0.05)
+}
+
+// darkenColor returns c scaled towards black as little as needed for a
+// contrast ratio of minContrast over light, black if not possible.
+func darkenColor(c color.Color, light color.Color, minContrast float64) color.Color {
+	if contrastRatio(light, c) >= minContrast {
+		return c
+	}
+
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	scale := func(f float64) color.NRGBA {
+		return color.NRGBA{uint8(float64(n.R) * f), uint8(float64(n.G) * f), uint8(float64(n.B) * f), 0xff}
+	}
+
+	// Binary search of the largest scale reaching the contrast.
+	low, high := 0.0, 1.0
+	for i := 0; i < 16; i++ {
+		mid := (low + high) / 2
+		if contrastRatio(light, scale(mid)) >= minContrast {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return scale(low)
+}
+
+// lerpColor returns the colour at t, from 0 to 1, of the gradient from a to b.
+func lerpColor(a color.Color, b color.Color, t float64) color.Color {
+	t = math.Max(0, math.Min(1, t))
+
+	na := color.NRGBAModel.Convert(a).(color.NRGBA)
+	nb := color.NRGBAModel.Convert(b).(color.NRGBA)
+
+	lerp := func(x uint8, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+
+	return color.NRGBA{lerp(na.R, nb.R), lerp(na.G, nb.G), lerp(na.B, nb.B), lerp(na.A, nb.A)}
+}
+
+// contrastThreshold returns the luminance, as returned by luminance, of the
+// lightest grey meeting the required contrast over BackgroundColor.
+func (q *HalftoneQRCode) contrastThreshold() int {
+	grey := darkenColor(color.White, q.option.BackgroundColor, q.minContrast())
+	return luminance(grey)
+}
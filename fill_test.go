@@ -0,0 +1,200 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFillColorAt(t *testing.T) {
+	black := color.NRGBA{0, 0, 0, 0xff}
+	blue := color.NRGBA{0, 0, 0xff, 0xff}
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+
+	tests := []struct {
+		fill     Fill
+		x, y     float64
+		expected color.Color
+	}{
+		{LinearGradient{From: black, To: blue}, 0, 10, black},
+		{LinearGradient{From: black, To: blue}, 21, 10, blue},
+		{LinearGradient{From: black, To: blue}, 10.5, 0, color.NRGBA{0, 0, 0x80, 0xff}},
+		{LinearGradient{From: black, To: blue, Angle: 90}, 10, 21, blue},
+		{LinearGradient{From: black, To: blue, Angle: 45}, 0, 0, black},
+		{LinearGradient{From: black, To: blue, Angle: 45}, 21, 21, blue},
+		{RadialGradient{Center: black, Edge: blue}, 10.5, 10.5, black},
+		{RadialGradient{Center: black, Edge: blue}, 21, 0, blue},
+		{RegionFill{Regions: []FillRegion{{image.Rect(0, 0, 7, 7), SolidFill{red}}}}, 3.5, 3.5, red},
+		{RegionFill{Regions: []FillRegion{{image.Rect(0, 0, 7, 7), SolidFill{red}}}}, 7.5, 3.5, nil},
+		{RegionFill{Default: SolidFill{blue}}, 7.5, 3.5, blue},
+	}
+
+	for i, test := range tests {
+		c := test.fill.ColorAt(FillPoint{X: test.x, Y: test.y, Size: 21})
+
+		if c != test.expected {
+			t.Errorf("fill %d: got %v at %g,%g, expected %v", i, c, test.x, test.y, test.expected)
+		}
+	}
+}
+
+func TestForegroundFill(t *testing.T) {
+	const moduleWidth = 9
+
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	darkRed := color.NRGBA{0x90, 0, 0, 0xff}
+
+	q.AddOption(Option{ForegroundFill: RegionFill{
+		Regions: []FillRegion{{image.Rect(0, 0, 7, 7), SolidFill{darkRed}}},
+	}})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The top left finder pattern is in the region, the others are not.
+	quietZone := q.symbol.quietZoneSize * moduleWidth
+	if c := img.At(quietZone+1, quietZone+1); color.NRGBAModel.Convert(c) != darkRed {
+		t.Errorf("got top left finder pattern %v, expected %v", c, darkRed)
+	}
+	black := color.NRGBAModel.Convert(color.Black)
+	if c := img.At(img.Bounds().Dx()-quietZone-1, quietZone+1); color.NRGBAModel.Convert(c) != black {
+		t.Errorf("got top right finder pattern %v, expected black", c)
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected the code to scan", err.Error())
+	}
+
+	// Yellow is too light on white.
+	q.AddOption(Option{ForegroundFill: LinearGradient{From: color.Black, To: color.NRGBA{0xff, 0xff, 0, 0xff}}})
+
+	_, err = q.CodeImage(3)
+	if e, ok := err.(*ContrastError); !ok || e.Ratio >= defaultMinContrast {
+		t.Errorf("got %v, expected a contrast error", err)
+	}
+
+	// Unless the contrast required is lowered.
+	q.AddOption(Option{MinContrast: 1})
+	if _, err := q.CodeImage(3); err != nil {
+		t.Errorf("got %s, expected success", err.Error())
+	}
+}
+
+func TestContrast(t *testing.T) {
+	yellow := color.RGBA{0xff, 0xff, 0, 0xff}
+
+	tests := []Option{
+		{ForegroundColor: yellow},
+		{BackgroundColor: color.RGBA{0x40, 0x40, 0x40, 0xff}},
+		{Eyes: &EyeStyle{OuterColor: yellow}},
+		{Eyes: &EyeStyle{InnerColor: yellow}},
+		{Eyes: &EyeStyle{AlignmentColor: yellow}},
+	}
+
+	for i, opt := range tests {
+		q, err := NewHalftoneCode("hello", Highest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q.AddOption(opt)
+
+		_, err = q.CodeImage(3)
+		if e, ok := err.(*ContrastError); !ok || e.Ratio >= defaultMinContrast {
+			t.Errorf("test %d: got %v, expected a contrast error", i, err)
+		}
+
+		// Vector output draws ForegroundColor only.
+		_, err = q.CodeSVG(3)
+		if _, ok := err.(*ContrastError); ok != (opt.Eyes == nil) {
+			t.Errorf("test %d: got %v from CodeSVG", i, err)
+		}
+	}
+}
+
+func TestMaskImageFill(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{ForegroundFill: MaskImageFill{}, MaskImageFile: bytes.NewReader(testMaskImage())})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	colors, err := q.moduleColors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without mask image, ForegroundColor.
+	for _, row := range colors {
+		for _, c := range row {
+			if c != nil && c != q.option.ForegroundColor {
+				t.Fatalf("got %v, expected ForegroundColor without mask image", c)
+			}
+		}
+	}
+
+	src, err := q.readMaskImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if colors, err = q.moduleColors(src); err != nil {
+		t.Fatal(err)
+	}
+
+	numColors := make(map[color.Color]bool)
+	for _, row := range colors {
+		for _, c := range row {
+			if c == nil {
+				continue
+			}
+
+			numColors[c] = true
+			if ratio := contrastRatio(color.White, c); ratio < defaultMinContrast {
+				t.Errorf("got contrast %g of %v, expected at least %g", ratio, c, defaultMinContrast)
+			}
+		}
+	}
+
+	if len(numColors) < 10 {
+		t.Errorf("got %d colours, expected the colours of the mask image", len(numColors))
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected the code to scan", err.Error())
+	}
+}
+
+func TestDarkenColor(t *testing.T) {
+	tests := []struct {
+		c           color.Color
+		light       color.Color
+		minContrast float64
+	}{
+		{color.NRGBA{0xff, 0xff, 0, 0xff}, color.White, 4.5},
+		{color.NRGBA{0x80, 0xc0, 0xff, 0xff}, color.White, 7},
+		{color.NRGBA{0x80, 0xc0, 0xff, 0xff}, color.NRGBA{0xff, 0xff, 0xe0, 0xff}, 3},
+	}
+
+	for i, test := range tests {
+		d := darkenColor(test.c, test.light, test.minContrast)
+
+		ratio := contrastRatio(test.light, d)
+		if ratio < test.minContrast || ratio > test.minContrast*1.1 {
+			t.Errorf("test %d: got contrast %g, expected just above %g", i, ratio, test.minContrast)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"sort"
+)
+
+// maxPaletteSize is the largest number of colours of a gif frame.
+const maxPaletteSize = 256
+
+// gifFrames composites the frames of g, which may only cover the area changed
+// since the previous frame, into images of the whole logical screen, disposing
+// of every frame as its disposal method tells.
+func gifFrames(g *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, len(g.Image))
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		composited := image.NewRGBA(bounds)
+		copy(composited.Pix, canvas.Pix)
+		frames[i] = composited
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// Decoders clear to transparent rather than to the background colour.
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return frames
+}
+
+// gifColors returns the colours the code is drawn with that the palettes of
+// the gif frames must hold exactly.
+func (q *HalftoneQRCode) gifColors() []color.Color {
+	colors := []color.Color{q.option.ForegroundColor, q.option.BackgroundColor}
+
+	if eyes := q.option.Eyes; eyes != nil {
+		colors = append(colors, eyes.OuterColor, eyes.InnerColor, eyes.AlignmentColor)
+	}
+	if q.option.Transparent {
+		colors = append(colors, color.Transparent)
+	}
+
+	return colors
+}
+
+// gifPalette returns the palette of a gif frame showing img: the fixed colours
+// first, then the colours of img. If img has too many colours, they are
+// reduced to the averages of the most frequent cells of a grid of 32 levels
+// per colour channel and 8 levels of alpha.
+func gifPalette(img image.Image, fixed []color.Color) color.Palette {
+	var palette color.Palette
+	seen := make(map[color.RGBA]bool)
+
+	add := func(c color.RGBA) {
+		if !seen[c] && len(palette) < maxPaletteSize {
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+
+	for _, c := range fixed {
+		if c != nil {
+			add(color.RGBAModel.Convert(c).(color.RGBA))
+		}
+	}
+
+	type cell struct {
+		key   uint32
+		count int
+
+		// Sums of the channels of the colours in the cell.
+		r, g, b, a int
+	}
+
+	counts := make(map[color.RGBA]int)
+	cells := make(map[uint32]*cell)
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			counts[c]++
+
+			key := uint32(c.R>>3)<<13 | uint32(c.G>>3)<<8 | uint32(c.B>>3)<<3 | uint32(c.A>>5)
+			if cells[key] == nil {
+				cells[key] = &cell{key: key}
+			}
+
+			p := cells[key]
+			p.count++
+			p.r, p.g, p.b, p.a = p.r+int(c.R), p.g+int(c.G), p.b+int(c.B), p.a+int(c.A)
+		}
+	}
+
+	if len(counts) <= maxPaletteSize-len(palette) {
+		colors := make([]color.RGBA, 0, len(counts))
+		for c := range counts {
+			colors = append(colors, c)
+		}
+
+		// Most frequent first, in a stable order.
+		sort.Slice(colors, func(i, j int) bool {
+			ci, cj := colors[i], colors[j]
+			if counts[ci] != counts[cj] {
+				return counts[ci] > counts[cj]
+			}
+			return uint32(ci.R)<<24|uint32(ci.G)<<16|uint32(ci.B)<<8|uint32(ci.A) <
+				uint32(cj.R)<<24|uint32(cj.G)<<16|uint32(cj.B)<<8|uint32(cj.A)
+		})
+
+		for _, c := range colors {
+			add(c)
+		}
+
+		return palette
+	}
+
+	sorted := make([]*cell, 0, len(cells))
+	for _, p := range cells {
+		sorted = append(sorted, p)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	for _, p := range sorted {
+		add(color.RGBA{uint8(p.r / p.count), uint8(p.g / p.count), uint8(p.b / p.count), uint8(p.a / p.count)})
+	}
+
+	return palette
+}
+
+// gifFrame returns img as a frame of a gif, mapping every pixel to the closest
+// colour of a palette holding the fixed colours exactly.
+func gifFrame(img image.Image, fixed []color.Color) *image.Paletted {
+	frame := image.NewPaletted(img.Bounds(), gifPalette(img, fixed))
+	draw.Draw(frame, frame.Rect, img, img.Bounds().Min, draw.Src)
+
+	return frame
+}
+
+// isTranslucent reports whether the palette has colours which are not opaque.
+func isTranslucent(palette color.Palette) bool {
+	for _, c := range palette {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return true
+		}
+	}
+
+	return false
+}
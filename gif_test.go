@@ -0,0 +1,181 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+var (
+	gifRed   = color.RGBA{0xff, 0, 0, 0xff}
+	gifGreen = color.RGBA{0, 0xff, 0, 0xff}
+	gifBlue  = color.RGBA{0, 0, 0xff, 0xff}
+)
+
+// testOptimizedGif returns a gif whose frames after the first only cover the
+// area they change, disposed of in every way.
+func testOptimizedGif() *gif.GIF {
+	p := color.Palette{gifRed, gifGreen, gifBlue, color.Transparent}
+
+	g := &gif.GIF{Config: image.Config{Width: 60, Height: 60, ColorModel: p}, LoopCount: 3}
+	for _, frame := range []struct {
+		r        image.Rectangle
+		c        color.Color
+		delay    int
+		disposal byte
+	}{
+		{image.Rect(0, 0, 60, 60), gifRed, 10, gif.DisposalNone},
+		{image.Rect(10, 10, 30, 30), gifGreen, 20, gif.DisposalBackground},
+		{image.Rect(30, 30, 50, 50), gifBlue, 30, gif.DisposalPrevious},
+		{image.Rect(0, 0, 5, 5), gifGreen, 40, gif.DisposalNone},
+	} {
+		m := image.NewPaletted(frame.r, p)
+		draw.Draw(m, m.Rect, image.NewUniform(frame.c), image.Point{}, draw.Src)
+
+		g.Image = append(g.Image, m)
+		g.Delay = append(g.Delay, frame.delay)
+		g.Disposal = append(g.Disposal, frame.disposal)
+	}
+
+	return g
+}
+
+func TestGifFrames(t *testing.T) {
+	frames := gifFrames(testOptimizedGif())
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, expected 4", len(frames))
+	}
+
+	tests := []struct {
+		frame    int
+		p        image.Point
+		expected color.RGBA
+	}{
+		{1, image.Pt(20, 20), gifGreen},
+		{1, image.Pt(5, 5), gifRed},
+		// Disposed of to transparent.
+		{2, image.Pt(20, 20), color.RGBA{}},
+		{2, image.Pt(40, 40), gifBlue},
+		// Disposed of to the previous frame.
+		{3, image.Pt(40, 40), gifRed},
+		{3, image.Pt(20, 20), color.RGBA{}},
+		{3, image.Pt(2, 2), gifGreen},
+	}
+
+	for _, test := range tests {
+		frame := frames[test.frame]
+		if frame.Bounds() != image.Rect(0, 0, 60, 60) {
+			t.Fatalf("frame %d got bounds %v, expected the whole image", test.frame, frame.Bounds())
+		}
+
+		if c := color.RGBAModel.Convert(frame.At(test.p.X, test.p.Y)); c != test.expected {
+			t.Errorf("frame %d got %v at %v, expected %v", test.frame, c, test.p, test.expected)
+		}
+	}
+}
+
+func TestGifPalette(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 0x80, 0xff})
+		}
+	}
+
+	fixed := []color.Color{color.RGBA{0x10, 0x20, 0x30, 0xff}, color.White, nil}
+
+	palette := gifPalette(img, fixed)
+	if len(palette) != maxPaletteSize {
+		t.Errorf("got %d colours, expected %d", len(palette), maxPaletteSize)
+	}
+
+	for i, c := range fixed[:2] {
+		if color.RGBAModel.Convert(palette[i]) != color.RGBAModel.Convert(c) {
+			t.Errorf("got colour %d %v, expected %v", i, palette[i], c)
+		}
+	}
+
+	// Few colours are kept exactly.
+	small := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	small.Set(1, 0, gifRed)
+
+	palette = gifPalette(small, fixed)
+	if len(palette) != 4 || palette[2] != (color.RGBA{}) || palette[3] != gifRed {
+		t.Errorf("got palette %v, expected the fixed colours, transparent and red", palette)
+	}
+}
+
+func TestCodeGif(t *testing.T) {
+	mask := testOptimizedGif()
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, mask); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither colour is in the palette of the mask gif.
+	foreground := color.RGBA{0x10, 0x20, 0x30, 0xff}
+	background := color.RGBA{0xf0, 0xf0, 0xe0, 0xff}
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(buf.Bytes()), ForegroundColor: foreground,
+		BackgroundColor: background})
+
+	g, err := q.CodeGif(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Image) != 4 || g.LoopCount != mask.LoopCount {
+		t.Fatalf("got %d frames looping %d times, expected 4 looping %d times", len(g.Image), g.LoopCount,
+			mask.LoopCount)
+	}
+
+	// The center of the top left finder pattern.
+	center := (q.symbol.quietZoneSize + 3) * 9
+
+	for i, frame := range g.Image {
+		if g.Delay[i] != mask.Delay[i] || g.Disposal[i] != mask.Disposal[i] {
+			t.Errorf("frame %d got delay %d disposal %d, expected %d %d", i, g.Delay[i], g.Disposal[i],
+				mask.Delay[i], mask.Disposal[i])
+		}
+
+		if frame.Bounds() != image.Rect(0, 0, g.Config.Width, g.Config.Height) {
+			t.Errorf("frame %d got bounds %v, expected the whole image", i, frame.Bounds())
+		}
+
+		if c := frame.At(center, center); c != foreground {
+			t.Errorf("frame %d got finder pattern %v, expected %v", i, c, foreground)
+		}
+		if c := frame.At(0, 0); c != background {
+			t.Errorf("frame %d got quiet zone %v, expected %v", i, c, background)
+		}
+
+		if _, err := q.Verify(frame); err != nil {
+			t.Errorf("frame %d got %s, expected the code to scan", i, err.Error())
+		}
+	}
+
+	// Transparent frames are disposed of to the background.
+	q.AddOption(Option{Transparent: true})
+
+	if g, err = q.CodeGif(3); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range g.Image {
+		if g.Disposal[i] != gif.DisposalBackground {
+			t.Errorf("frame %d got disposal %d, expected %d", i, g.Disposal[i], gif.DisposalBackground)
+		}
+	}
+
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Errorf("got %s, expected the gif to encode", err.Error())
+	}
+}
@@ -0,0 +1,286 @@
+package qart
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xrlin/qart/bitset"
+)
+
+// FNC1Mode selects the FNC1 mode of the content, which tells readers that the
+// data is formatted according to an industry standard.
+type FNC1Mode uint8
+
+const (
+	// NoFNC1 encodes the content as plain data.
+	NoFNC1 FNC1Mode = iota
+
+	// FNC1First marks the data as formatted according to the GS1 General
+	// Specifications, see NewGS1HalftoneCode.
+	FNC1First
+
+	// FNC1Second marks the data as formatted according to the industry
+	// application identified by EncodeOption.ApplicationIndicator.
+	FNC1Second
+)
+
+// gs1GroupSeparator separates a variable length GS1 element from the next
+// element. It is encoded as the FNC1 character.
+const gs1GroupSeparator = 0x1d
+
+// appendFNC1 appends the FNC1 mode indicator to encoded.
+//
+// FNC1 in first position is the mode indicator 0101. FNC1 in second position
+// is the mode indicator 1001 followed by the 8-bit application indicator: a
+// two digit number (00-99) is encoded as its value, a single letter (a-z, A-Z)
+// as its ASCII value + 100.
+func appendFNC1(mode FNC1Mode, applicationIndicator string, encoded *bitset.Bitset) error {
+	switch mode {
+	case FNC1First:
+		encoded.Append(bitset.New(b0, b1, b0, b1))
+	case FNC1Second:
+		v, err := encodeApplicationIndicator(applicationIndicator)
+		if err != nil {
+			return err
+		}
+
+		encoded.Append(bitset.New(b1, b0, b0, b1))
+		encoded.AppendByte(v, 8)
+	default:
+		return errors.New("unknown FNC1 mode")
+	}
+
+	return nil
+}
+
+// encodeApplicationIndicator returns the 8-bit value of an FNC1 in second
+// position application indicator.
+func encodeApplicationIndicator(indicator string) (byte, error) {
+	switch {
+	case len(indicator) == 1 && (indicator[0] >= 'a' && indicator[0] <= 'z' ||
+		indicator[0] >= 'A' && indicator[0] <= 'Z'):
+		return indicator[0] + 100, nil
+	case len(indicator) == 2 && isNumericCharacter(indicator[0]) && isNumericCharacter(indicator[1]):
+		return (indicator[0]-'0')*10 + indicator[1] - '0', nil
+	}
+
+	return 0, errors.New("application indicator must be a letter or two digits")
+}
+
+// gs1AI describes the format of the data field of a GS1 Application
+// Identifier.
+type gs1AI struct {
+	// Minimum and maximum length of the data field.
+	minLength int
+	maxLength int
+
+	// True if the data field is all digits.
+	numeric bool
+
+	// True if the data field ends with a GS1 check digit.
+	checkDigit bool
+}
+
+// gs1AIs lists the supported GS1 Application Identifiers.
+//
+// AIs with a decimal point indicator in their last digit (e.g. 310n) are
+// listed for each value of n.
+var gs1AIs = map[string]gs1AI{
+	"00":   {18, 18, true, true},   // SSCC
+	"01":   {14, 14, true, true},   // GTIN
+	"02":   {14, 14, true, true},   // GTIN of contained trade items
+	"10":   {1, 20, false, false},  // Batch or lot number
+	"11":   {6, 6, true, false},    // Production date
+	"12":   {6, 6, true, false},    // Due date
+	"13":   {6, 6, true, false},    // Packaging date
+	"15":   {6, 6, true, false},    // Best before date
+	"16":   {6, 6, true, false},    // Sell by date
+	"17":   {6, 6, true, false},    // Expiration date
+	"20":   {2, 2, true, false},    // Internal product variant
+	"21":   {1, 20, false, false},  // Serial number
+	"22":   {1, 20, false, false},  // Consumer product variant
+	"240":  {1, 30, false, false},  // Additional product identification
+	"241":  {1, 30, false, false},  // Customer part number
+	"250":  {1, 30, false, false},  // Secondary serial number
+	"251":  {1, 30, false, false},  // Reference to source entity
+	"253":  {13, 30, false, false}, // Global Document Type Identifier
+	"254":  {1, 20, false, false},  // GLN extension component
+	"30":   {1, 8, true, false},    // Variable count of items
+	"37":   {1, 8, true, false},    // Count of trade items
+	"400":  {1, 30, false, false},  // Customer's purchase order number
+	"401":  {1, 30, false, false},  // Global Identification Number for Consignment
+	"402":  {17, 17, true, true},   // Global Shipment Identification Number
+	"403":  {1, 30, false, false},  // Routing code
+	"410":  {13, 13, true, true},   // Ship to GLN
+	"411":  {13, 13, true, true},   // Bill to GLN
+	"412":  {13, 13, true, true},   // Purchased from GLN
+	"413":  {13, 13, true, true},   // Ship for GLN
+	"414":  {13, 13, true, true},   // Identification of a physical location GLN
+	"415":  {13, 13, true, true},   // GLN of the invoicing party
+	"420":  {1, 20, false, false},  // Ship to postal code
+	"422":  {3, 3, true, false},    // Country of origin
+	"7003": {10, 10, true, false},  // Expiration date and time
+	"8005": {6, 6, true, false},    // Price per unit of measure
+	"8020": {1, 25, false, false},  // Payment slip reference number
+	"90":   {1, 30, false, false},  // Mutually agreed information
+}
+
+func init() {
+	// Trade measures with the decimal point position as the last AI digit.
+	for _, prefix := range []string{"310", "311", "312", "313", "314", "315", "316",
+		"320", "321", "322", "323", "324", "325", "326", "327", "328", "329",
+		"330", "331", "332", "333", "334", "335", "336", "337",
+		"340", "341", "342", "343", "344", "345", "346", "347", "348", "349",
+		"350", "351", "352", "353", "354", "355", "356", "357",
+		"360", "361", "362", "363", "364", "365", "366", "367", "368", "369",
+		"390", "392"} {
+		for n := 0; n <= 9; n++ {
+			ai := gs1AI{6, 6, true, false}
+			if prefix == "390" || prefix == "392" {
+				// Amount payable.
+				ai = gs1AI{1, 15, true, false}
+			}
+
+			gs1AIs[prefix+strconv.Itoa(n)] = ai
+		}
+	}
+
+	// Company internal information.
+	for n := 91; n <= 99; n++ {
+		gs1AIs[strconv.Itoa(n)] = gs1AI{1, 90, false, false}
+	}
+}
+
+// gs1PredefinedLength lists the AI prefixes whose data fields have a length
+// predefined by the GS1 General Specifications. All other elements must be
+// followed by a group separator unless they end the data.
+var gs1PredefinedLength = map[string]bool{
+	"00": true, "01": true, "02": true, "03": true, "04": true,
+	"11": true, "12": true, "13": true, "14": true, "15": true, "16": true,
+	"17": true, "18": true, "19": true, "20": true, "31": true, "32": true,
+	"33": true, "34": true, "35": true, "36": true, "41": true,
+}
+
+// ParseGS1 converts a human readable GS1 element string such as
+// "(01)09501101530003(17)250101" into the data encoded in a GS1 QR Code.
+//
+// Every Application Identifier and its data field are validated, including
+// check digits. Elements of variable length are terminated by a group
+// separator (0x1d) unless they are the last element.
+//
+// An error occurs if the element string is malformed or uses an unsupported
+// Application Identifier.
+func ParseGS1(elementString string) (string, error) {
+	if !strings.HasPrefix(elementString, "(") {
+		return "", errors.New("GS1 element string must start with an application identifier")
+	}
+
+	var buf []byte
+
+	for rest := elementString; rest != ""; {
+		end := strings.IndexByte(rest, ')')
+		if !strings.HasPrefix(rest, "(") || end < 0 {
+			return "", errors.New("GS1 element string has an unterminated application identifier")
+		}
+
+		id := rest[1:end]
+		rest = rest[end+1:]
+
+		value := rest
+		if next := strings.IndexByte(rest, '('); next >= 0 {
+			value = rest[:next]
+		}
+		rest = rest[len(value):]
+
+		ai, ok := gs1AIs[id]
+		if !ok {
+			return "", fmt.Errorf("unknown GS1 application identifier (%s)", id)
+		}
+
+		if err := ai.validate(value); err != nil {
+			return "", fmt.Errorf("GS1 application identifier (%s): %s", id, err.Error())
+		}
+
+		buf = append(buf, id...)
+		buf = append(buf, value...)
+
+		if rest != "" && !gs1PredefinedLength[id[:2]] {
+			buf = append(buf, gs1GroupSeparator)
+		}
+	}
+
+	return string(buf), nil
+}
+
+// validate returns an error if value is not a valid data field for the AI.
+func (ai gs1AI) validate(value string) error {
+	if len(value) < ai.minLength || len(value) > ai.maxLength {
+		if ai.minLength == ai.maxLength {
+			return fmt.Errorf("data must be %d characters long", ai.minLength)
+		}
+
+		return fmt.Errorf("data must be %d to %d characters long", ai.minLength, ai.maxLength)
+	}
+
+	for i := 0; i < len(value); i++ {
+		if ai.numeric && !isNumericCharacter(value[i]) {
+			return errors.New("data must be numeric")
+		} else if !isGS1Character(value[i]) {
+			return fmt.Errorf("invalid character %q", value[i])
+		}
+	}
+
+	if ai.checkDigit && gs1CheckDigit(value[:len(value)-1]) != value[len(value)-1] {
+		return errors.New("invalid check digit")
+	}
+
+	return nil
+}
+
+// isGS1Character returns true if v is in the GS1 AI encodable character set 82.
+func isGS1Character(v byte) bool {
+	switch {
+	case v >= '0' && v <= '9', v >= 'A' && v <= 'Z', v >= 'a' && v <= 'z':
+		return true
+	}
+
+	return strings.IndexByte("!\"%&'()*+,-./:;<=>?_", v) >= 0
+}
+
+// gs1CheckDigit returns the GS1 mod 10 check digit of the digits in data.
+//
+// Digits are weighted 3 and 1 alternately, starting with 3 from the rightmost
+// digit.
+func gs1CheckDigit(data string) byte {
+	sum := 0
+	for i := 0; i < len(data); i++ {
+		weight := 1
+		if (len(data)-i)%2 == 1 {
+			weight = 3
+		}
+
+		sum += weight * int(data[i]-'0')
+	}
+
+	return byte((10-sum%10)%10) + '0'
+}
+
+// NewGS1HalftoneCode constructs a GS1 QR Code from a human readable GS1
+// element string, see ParseGS1.
+//
+//	q, err := qart.NewGS1HalftoneCode("(01)09501101530003(17)250101", qart.Medium)
+//
+// The Content of the code is the encoded data, with group separators between
+// variable length elements.
+//
+// An error occurs if the element string is invalid or too long.
+func NewGS1HalftoneCode(elementString string, level RecoveryLevel) (*HalftoneQRCode, error) {
+	content, err := ParseGS1(elementString)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHalftoneCodeWithOption(content, level, EncodeOption{FNC1: FNC1First})
+}
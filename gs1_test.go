@@ -0,0 +1,191 @@
+package qart
+
+import (
+	"testing"
+
+	"github.com/xrlin/qart/bitset"
+)
+
+func TestParseGS1(t *testing.T) {
+	tests := []struct {
+		elementString string
+		expected      string
+		expectError   bool
+	}{
+		{
+			"(01)09501101530003(17)250101",
+			"010950110153000317250101",
+			false,
+		},
+		// Variable length elements are terminated by a group separator, unless
+		// last.
+		{
+			"(10)ABC123(21)12345",
+			"10ABC123\x1d2112345",
+			false,
+		},
+		{
+			"(01)09501101530003(10)AB-1(3103)000189",
+			"010950110153000310AB-1\x1d3103000189",
+			false,
+		},
+		{
+			"(00)095011015300000010",
+			"00095011015300000010",
+			false,
+		},
+		// Invalid check digit.
+		{
+			"(01)09501101530004",
+			"",
+			true,
+		},
+		// Wrong length.
+		{
+			"(17)2501",
+			"",
+			true,
+		},
+		// Non numeric data.
+		{
+			"(17)25010A",
+			"",
+			true,
+		},
+		// Unknown AI.
+		{
+			"(999)1",
+			"",
+			true,
+		},
+		// Invalid character.
+		{
+			"(10)AB#1",
+			"",
+			true,
+		},
+		{
+			"01095011015300003",
+			"",
+			true,
+		},
+		{
+			"(01",
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		data, err := ParseGS1(test.elementString)
+
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%q parsed, expected error", test.elementString)
+			}
+		} else if err != nil {
+			t.Errorf("%q got %s, expected success", test.elementString, err.Error())
+		} else if data != test.expected {
+			t.Errorf("%q got %q, expected %q", test.elementString, data, test.expected)
+		}
+	}
+}
+
+func TestGS1CheckDigit(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected byte
+	}{
+		{"0950110153000", '3'},
+		{"09501101530000001", '0'},
+		{"629104150021", '3'},
+		{"0", '0'},
+	}
+
+	for _, test := range tests {
+		if c := gs1CheckDigit(test.data); c != test.expected {
+			t.Errorf("%s got check digit %c, expected %c", test.data, c, test.expected)
+		}
+	}
+}
+
+func TestEncodeFNC1(t *testing.T) {
+	tests := []struct {
+		fnc1                 FNC1Mode
+		applicationIndicator string
+		data                 string
+		expected             *bitset.Bitset
+	}{
+		{
+			FNC1First,
+			"",
+			"01",
+			bitset.NewFromBase2String("0101 0001 0000000010 0000001"),
+		},
+		// The group separator is encoded as '%' in alphanumeric mode.
+		{
+			FNC1First,
+			"",
+			"A\x1dB",
+			bitset.NewFromBase2String("0101 0010 000000011 00111101000 001011"),
+		},
+		// A literal '%' is encoded in byte mode.
+		{
+			FNC1First,
+			"",
+			"%",
+			bitset.NewFromBase2String("0101 0100 00000001 00100101"),
+		},
+		{
+			FNC1Second,
+			"37",
+			"1",
+			bitset.NewFromBase2String("1001 00100101 0001 0000000001 0001"),
+		},
+		{
+			FNC1Second,
+			"a",
+			"1",
+			bitset.NewFromBase2String("1001 11000101 0001 0000000001 0001"),
+		},
+	}
+
+	for _, test := range tests {
+		encoder := newDataEncoder(dataEncoderType1To9)
+		encoder.fnc1 = test.fnc1
+		encoder.applicationIndicator = test.applicationIndicator
+
+		encoded, err := encoder.encode([]byte(test.data))
+		if err != nil {
+			t.Errorf("For %q got %s, expected success", test.data, err.Error())
+		} else if !test.expected.Equals(encoded) {
+			t.Errorf("For %q got %s, expected %s", test.data, encoded.String(),
+				test.expected.String())
+		}
+	}
+
+	for _, indicator := range []string{"", "1", "123", "%"} {
+		encoder := newDataEncoder(dataEncoderType1To9)
+		encoder.fnc1 = FNC1Second
+		encoder.applicationIndicator = indicator
+
+		if _, err := encoder.encode([]byte("1")); err == nil {
+			t.Errorf("Application indicator %q encoded, expected error", indicator)
+		}
+	}
+}
+
+func TestNewGS1HalftoneCode(t *testing.T) {
+	q, err := NewGS1HalftoneCode("(01)09501101530003(10)AB-1(17)250101", Medium)
+	if err != nil {
+		t.Fatalf("got %s, expected success", err.Error())
+	}
+
+	if q.Content != "010950110153000310AB-1\x1d17250101" {
+		t.Errorf("got content %q", q.Content)
+	}
+
+	if _, err := NewGS1HalftoneCode("(01)09501101530004", Medium); err == nil {
+		t.Error("invalid check digit encoded, expected error")
+	}
+}
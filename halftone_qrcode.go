@@ -3,9 +3,11 @@ package qart
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"github.com/disintegration/imaging"
 	"github.com/xrlin/qart/bitset"
 	"github.com/xrlin/qart/reedsolomon"
+	"golang.org/x/image/vector"
 	"image"
 	"image/color"
 	"image/png"
@@ -35,12 +37,25 @@ type HalftoneQRCode struct {
 	data   *bitset.Bitset
 	symbol *HalftoneSymbol
 
+	// Number of leading bits of data holding the content and the terminator.
+	// Decoders ignore the padding bits after them.
+	numContentBits int
+
+	// Modules of the symbol, without quiet zone, reserved for a logo by
+	// EncodeOption.LogoSize.
+	logoArea image.Rectangle
+
 	mask int
 
 	option *Option
 }
 
 // Option struct contains the option to build code
+//
+// Vector output (CodeSVG, CodePDF and CodeEPS) ignores the fields for the
+// images drawn only: QuietZoneImage, DataModuleShape, FunctionModuleShape,
+// ForegroundFill, the logo fields, Transparent and Eyes. It draws square
+// modules in ForegroundColor and BackgroundColor instead.
 type Option struct {
 	// User settable drawing options.
 	ForegroundColor color.Color
@@ -52,6 +67,192 @@ type Option struct {
 	MaskRectangle image.Rectangle
 
 	Embed bool
+
+	// Size sets the width and height in pixels of the images drawn, overriding
+	// pointWidth. The modules are as wide as fits, the pixels left over pad the
+	// code with BackgroundColor.
+	Size int
+
+	// Grid sets the number of blocks, from 2 to 7, each module is split into
+	// per side, 3 by default. CoreSize sets the number of blocks per side of
+	// the centered core of a module drawn with the module colour, 1 by default.
+	// The rest of the module shows the mask image. The larger the core, the
+	// more reliable the scan but the less visible the image.
+	Grid     int
+	CoreSize int
+
+	// BlendFunctionPatterns draws the finder, alignment and timing patterns
+	// and the format and version information like the data modules, with the
+	// mask image around their core. They are solid by default.
+	BlendFunctionPatterns bool
+
+	// QuietZone sets the width in modules of the light border around the
+	// symbol, 4 by default as the specification requires. NoQuietZone draws
+	// none, for codes placed on a light background of their own. The quiet
+	// zone is BackgroundColor, or shows the mask image lightened with
+	// QuietZoneImage.
+	QuietZone      int
+	NoQuietZone    bool
+	QuietZoneImage bool
+
+	// DataModuleShape sets the shape of the data modules, FunctionModuleShape
+	// the shape of the function patterns, plain squares if nil. Modules
+	// showing the mask image shape their core. The shapes are anti-aliased.
+	DataModuleShape     ModuleShape
+	FunctionModuleShape ModuleShape
+
+	// ForegroundFill sets the colour of every dark module, for gradients or
+	// colours taken from the mask image. The dark modules, of ForegroundColor,
+	// the fill or the eye colours, must keep a contrast ratio of at least
+	// MinContrast (from 1 to 21, 4.5 by default) over BackgroundColor, or a
+	// *ContrastError is returned.
+	ForegroundFill Fill
+	MinContrast    float64
+
+	// LogoImagePath or LogoImageFile sets a logo drawn over the area reserved
+	// by EncodeOption.LogoSize, fitted within LogoPadding pixels of its edges.
+	// LogoPlateColor sets the colour of a plate drawn behind the logo, with
+	// corners rounded by LogoPlateRadius (from 0 to 0.5) times its width.
+	LogoImagePath   string
+	LogoImageFile   io.Reader
+	LogoPadding     int
+	LogoPlateColor  color.Color
+	LogoPlateRadius float64
+
+	// Transparent draws the light modules transparent, for codes laid over
+	// web pages, but the quiet zone and the finder patterns with their
+	// separators, drawn on an opaque BackgroundColor for the scanners to find
+	// the code.
+	Transparent bool
+
+	// Eyes draws the finder and alignment patterns in their own style rather
+	// than like the other modules.
+	Eyes *EyeStyle
+
+	// Dither reduces the mask image to a binary halftone, black and white or,
+	// with DitherColor, the 8 colours with every channel either off or on.
+	Dither      DitherMode
+	DitherColor bool
+
+	// ControlModules chooses the padding of the data so that the modules of
+	// the code follow the mask image, the first frame of a gif. Only the
+	// modules after the content and of the error correction of the same blocks
+	// can follow the image, choose a version larger than the content requires
+	// to leave room, see EncodeOption.MinVersion. The code keeps the modules
+	// chosen.
+	ControlModules bool
+
+	// Saliency draws the mask image only around the modules where it matters:
+	// the modules of a colour other than the mask image around them are solid,
+	// unless important enough. The importance is the luminance of the weight
+	// image, or the gradient magnitude of the mask image if not set. In every
+	// block, at most ErrorBudget (from 0 to 1, 0.5 by default) of the error
+	// correction capacity is spent on such modules and on the logo.
+	// NoErrorBudget spends none, every module at risk being solid.
+	Saliency        bool
+	WeightImagePath string
+	WeightImageFile io.Reader
+	ErrorBudget     float64
+	NoErrorBudget   bool
+
+	// LinkMaskImage makes vector output link to MaskImagePath rather than
+	// embed the mask image. The linked image is shown as is, without dithering.
+	LinkMaskImage bool
+
+	// Verify makes ImageData check the rendered code is still scannable, and
+	// return an *UnscannableError if it is not. See Verify.
+	Verify bool
+}
+
+// EncodeOption struct contains the options used to encode the content of a code.
+// The zero value encodes the content the same way as NewHalftoneCode.
+type EncodeOption struct {
+	// Charset declares the character set of the content. An ECI segment is
+	// emitted unless the content is ASCII and the charset extends ASCII.
+	Charset Charset
+
+	// FNC1 marks the content as formatted according to an industry standard.
+	FNC1 FNC1Mode
+
+	// ApplicationIndicator identifies the industry application when FNC1 is
+	// FNC1Second: a letter or two digits.
+	ApplicationIndicator string
+
+	// Version fixes the version of the symbol (1-40). The zero value chooses the
+	// smallest version able to hold the content.
+	Version int
+
+	// MinVersion is the smallest version chosen when Version is not set. Larger
+	// versions have more modules, which reproduce the image more faithfully.
+	MinVersion int
+
+	// ForceMask uses the data mask pattern Mask (0-7) instead of the pattern
+	// with the lowest penalty score.
+	ForceMask bool
+	Mask      int
+
+	// BoostLevel raises the error recovery level to the highest level which still
+	// fits the content in the chosen version. Halftone codes rely on error
+	// correction to absorb the noise of the image.
+	BoostLevel bool
+
+	// LogoSize reserves a square at the center of the symbol, from 0 to 0.5
+	// times its width, for the logo set by Option.LogoImagePath. The version
+	// and then the error recovery level are raised until every block corrects
+	// the codewords under the logo within half of its capacity, a *LogoError
+	// is returned if none allowed does.
+	LogoSize float64
+}
+
+var (
+	// ErrInvalidVersion is returned when EncodeOption requests a version outside
+	// of 1-40, or a minimum version larger than the fixed version.
+	ErrInvalidVersion = errors.New("version must be between 1 and 40")
+
+	// ErrInvalidMask is returned when EncodeOption forces a mask outside of 0-7.
+	ErrInvalidMask = errors.New("mask must be between 0 and 7")
+)
+
+// CapacityError is returned when the content is too long to encode in the
+// versions allowed by EncodeOption.
+type CapacityError struct {
+	// Error recovery level requested.
+	Level RecoveryLevel
+
+	// Smallest and largest version tried.
+	MinVersion int
+	MaxVersion int
+}
+
+func (e *CapacityError) Error() string {
+	switch {
+	case e.MinVersion == e.MaxVersion:
+		return fmt.Sprintf("content too long to encode in version %d", e.MinVersion)
+	case e.MinVersion > 1:
+		return fmt.Sprintf("content too long to encode in versions %d-%d", e.MinVersion, e.MaxVersion)
+	}
+
+	return "content too long to encode"
+}
+
+// versionRange returns the smallest and largest version allowed by opt.
+func (opt EncodeOption) versionRange() (int, int, error) {
+	const minVersion, maxVersion = 1, 40
+
+	switch {
+	case opt.Version < 0 || opt.Version > maxVersion:
+		return 0, 0, ErrInvalidVersion
+	case opt.MinVersion < 0 || opt.MinVersion > maxVersion:
+		return 0, 0, ErrInvalidVersion
+	case opt.Version != 0 && opt.MinVersion > opt.Version:
+		return 0, 0, ErrInvalidVersion
+	case opt.Version != 0:
+		return opt.Version, opt.Version, nil
+	case opt.MinVersion != 0:
+		return opt.MinVersion, maxVersion, nil
+	}
+
+	return minVersion, maxVersion, nil
 }
 
 // OptionKey act as the key of Option struct
@@ -70,6 +271,62 @@ const (
 	MaskRectangleOpt   OptionKey = "MaskRectangle"
 	// Field name of Embed in Option
 	EmbedOpt           OptionKey = "Embed"
+	// Field name of Size in Option
+	SizeOpt            OptionKey = "Size"
+	// Field name of Grid in Option
+	GridOpt            OptionKey = "Grid"
+	// Field name of CoreSize in Option
+	CoreSizeOpt        OptionKey = "CoreSize"
+	// Field name of BlendFunctionPatterns in Option
+	BlendFunctionPatternsOpt OptionKey = "BlendFunctionPatterns"
+	// Field name of QuietZone in Option
+	QuietZoneOpt       OptionKey = "QuietZone"
+	// Field name of NoQuietZone in Option
+	NoQuietZoneOpt     OptionKey = "NoQuietZone"
+	// Field name of QuietZoneImage in Option
+	QuietZoneImageOpt  OptionKey = "QuietZoneImage"
+	// Field name of DataModuleShape in Option
+	DataModuleShapeOpt OptionKey = "DataModuleShape"
+	// Field name of FunctionModuleShape in Option
+	FunctionModuleShapeOpt OptionKey = "FunctionModuleShape"
+	// Field name of ForegroundFill in Option
+	ForegroundFillOpt  OptionKey = "ForegroundFill"
+	// Field name of MinContrast in Option
+	MinContrastOpt     OptionKey = "MinContrast"
+	// Field name of LogoImagePath in Option
+	LogoImagePathOpt   OptionKey = "LogoImagePath"
+	// Field name of LogoImageFile in Option
+	LogoImageFileOpt   OptionKey = "LogoImageFile"
+	// Field name of LogoPadding in Option
+	LogoPaddingOpt     OptionKey = "LogoPadding"
+	// Field name of LogoPlateColor in Option
+	LogoPlateColorOpt  OptionKey = "LogoPlateColor"
+	// Field name of LogoPlateRadius in Option
+	LogoPlateRadiusOpt OptionKey = "LogoPlateRadius"
+	// Field name of Transparent in Option
+	TransparentOpt     OptionKey = "Transparent"
+	// Field name of Eyes in Option
+	EyesOpt            OptionKey = "Eyes"
+	// Field name of Dither in Option
+	DitherOpt          OptionKey = "Dither"
+	// Field name of DitherColor in Option
+	DitherColorOpt     OptionKey = "DitherColor"
+	// Field name of ControlModules in Option
+	ControlModulesOpt  OptionKey = "ControlModules"
+	// Field name of Saliency in Option
+	SaliencyOpt        OptionKey = "Saliency"
+	// Field name of WeightImagePath in Option
+	WeightImagePathOpt OptionKey = "WeightImagePath"
+	// Field name of WeightImageFile in Option
+	WeightImageFileOpt OptionKey = "WeightImageFile"
+	// Field name of ErrorBudget in Option
+	ErrorBudgetOpt     OptionKey = "ErrorBudget"
+	// Field name of NoErrorBudget in Option
+	NoErrorBudgetOpt   OptionKey = "NoErrorBudget"
+	// Field name of LinkMaskImage in Option
+	LinkMaskImageOpt   OptionKey = "LinkMaskImage"
+	// Field name of Verify in Option
+	VerifyOpt          OptionKey = "Verify"
 )
 
 // AddOption add Option to a HalftoneQRCode.
@@ -79,10 +336,12 @@ func (q *HalftoneQRCode) AddOption(cfg Option) *HalftoneQRCode {
 	preValue := reflect.ValueOf(q.option).Elem()
 	values := reflect.ValueOf(cfg)
 	for i := 0; i < values.NumField(); i++ {
-		if values.Field(i).Interface() != reflect.Zero(values.Field(i).Type()).Interface() {
+		// Fields like RegionFill fills are not comparable.
+		if !values.Field(i).IsZero() {
 			preValue.Field(i).Set(values.Field(i))
 		}
 	}
+	q.resizeQuietZone()
 	return q
 }
 
@@ -96,6 +355,7 @@ func (q *HalftoneQRCode) RemoveOption(opt OptionKey) *HalftoneQRCode {
 	preValue := reflect.ValueOf(q.option).Elem()
 	field := preValue.FieldByName(string(opt))
 	field.Set(reflect.Zero(field.Type()))
+	q.resizeQuietZone()
 	return q
 }
 
@@ -116,7 +376,8 @@ func (q *HalftoneQRCode) getMaskImageFile() (f io.Reader, err error) {
 }
 
 // ImageData generate code and return the bytes represents the cod image(png/gif).
-// pointWidth parameter set the width of a qr code module.
+// pointWidth is as for CodeImage.
+// If the Verify option is set, every image is checked to be scannable.
 func (q *HalftoneQRCode) ImageData(pointWidth int) (ret []byte, err error) {
 	fileObj, err := q.getMaskImageFile()
 	if err != nil {
@@ -134,6 +395,13 @@ func (q *HalftoneQRCode) ImageData(pointWidth int) (ret []byte, err error) {
 		if err != nil {
 			return
 		}
+		if q.option.Verify {
+			for _, frame := range gifCode.Image {
+				if _, err = q.Verify(frame); err != nil {
+					return
+				}
+			}
+		}
 		err = gif.EncodeAll(&buf, gifCode)
 		ret = buf.Bytes()
 		return
@@ -142,6 +410,11 @@ func (q *HalftoneQRCode) ImageData(pointWidth int) (ret []byte, err error) {
 	if err != nil {
 		return
 	}
+	if q.option.Verify {
+		if _, err = q.Verify(imgCode); err != nil {
+			return
+		}
+	}
 	encoder := png.Encoder{CompressionLevel: png.BestCompression}
 
 	err = encoder.Encode(&buf, imgCode)
@@ -160,27 +433,43 @@ func (q *HalftoneQRCode) readAsImage(f io.Reader) (maskImage image.Image, err er
 }
 
 // CodeImage generate the code as a normal image.
-// pointWidth parameter set the width of a qr code module.
+// pointWidth sets the width in pixels of a block of a module, every module
+// being Grid (3 by default) blocks wide, unless the Size option is set.
 func (q *HalftoneQRCode) CodeImage(pointWidth int) (ret image.Image, err error) {
-	fileObj, err := q.getMaskImageFile()
+	srcImg, err := q.readMaskImage()
 	if err != nil {
 		return
 	}
 
-	var srcImg image.Image
-	if fileObj != nil {
-		srcImg, err = q.readAsImage(fileObj)
-		if err != nil {
-			return
-		}
+	ret, err = q.drawCodeWithImage(pointWidth, srcImg)
+	return
+}
+
+// readMaskImage reads the mask image, the first frame of a gif, or returns nil
+// without mask image. The modules follow the mask image if ControlModules is
+// set.
+func (q *HalftoneQRCode) readMaskImage() (srcImg image.Image, err error) {
+	fileObj, err := q.getMaskImageFile()
+	if err != nil || fileObj == nil {
+		return
 	}
 
-	ret, err = q.drawCodeWithImage(pointWidth, srcImg)
+	srcImg, err = q.readAsImage(fileObj)
+	if err != nil {
+		return
+	}
+
+	if q.option.ControlModules {
+		err = q.followImage(srcImg)
+	}
 	return
 }
 
-// CodeGif generates the code as a gif.
-// pointWidth parameter set the width of a qr code module.
+// CodeGif generates the code as a gif, pointWidth is as for CodeImage.
+// The frames of the mask gif are composited as a viewer shows them before the
+// code is drawn over each, and every frame gets a palette holding the colours
+// of the code exactly. The delays, disposal methods and loop count are kept,
+// but frames with transparent pixels are disposed of to the background.
 func (q *HalftoneQRCode) CodeGif(pointWidth int) (ret *gif.GIF, err error) {
 	fileObj, err := q.getMaskImageFile()
 	if err != nil {
@@ -192,16 +481,32 @@ func (q *HalftoneQRCode) CodeGif(pointWidth int) (ret *gif.GIF, err error) {
 		return
 	}
 
-	for idx, img := range maskGif.Image {
+	frames := gifFrames(maskGif)
+
+	if q.option.ControlModules && len(frames) > 0 {
+		if err = q.followImage(frames[0]); err != nil {
+			return
+		}
+	}
+
+	for idx, img := range frames {
 		img1, err := q.drawCodeWithImage(pointWidth, img)
 		if err != nil {
 			return ret, err
 		}
-		palettedImage := image.NewPaletted(img1.Bounds(), img.Palette)
-		draw.Draw(palettedImage, palettedImage.Rect, img1, img1.Bounds().Min, draw.Over)
+		palettedImage := gifFrame(img1, q.gifColors())
 		maskGif.Image[idx] = palettedImage
 		maskGif.Config.Height = img1.Bounds().Size().Y
 		maskGif.Config.Width = img1.Bounds().Size().X
+
+		// The frames cover the whole image, the transparent pixels must not
+		// show the previous frame.
+		if isTranslucent(palettedImage.Palette) {
+			for len(maskGif.Disposal) < len(maskGif.Image) {
+				maskGif.Disposal = append(maskGif.Disposal, 0)
+			}
+			maskGif.Disposal[idx] = gif.DisposalBackground
+		}
 	}
 	ret = maskGif
 	return
@@ -224,97 +529,214 @@ func (q *HalftoneQRCode) getMaskAreaImage(sourceImage image.Image, bounds image.
 		}
 		maskImage = imaging.Crop(maskImage, q.option.MaskRectangle)
 	}
-	maskImage = imaging.Resize(maskImage, bounds.Max.X, bounds.Max.Y, imaging.Lanczos)
+	maskImage = imaging.Resize(maskImage, bounds.Dx(), bounds.Dy(), imaging.Lanczos)
+	if q.option.Dither != NoDither {
+		maskImage, err = dither(maskImage, q.option.Dither, q.option.DitherColor)
+	}
 	return
 }
 
-func (q *HalftoneQRCode) drawCodeWithImage(pointWidth int, sourceImage image.Image) (image.Image, error) {
-	// Minimum pixels (both width and height) required.
-	realSize := q.symbol.size
+// Bounds of the halftone sub-module grid.
+const (
+	minHalftoneGrid = 2
+	maxHalftoneGrid = 7
+)
 
-	var size int
-	// Variable size support.
-	if pointWidth < 0 {
-		pointWidth = 1
+// halftoneGrid returns the number of blocks a module is split into per side,
+// and the number of blocks per side of the core carrying the module colour.
+func (q *HalftoneQRCode) halftoneGrid() (grid int, core int, err error) {
+	grid, core = q.option.Grid, q.option.CoreSize
+	if grid == 0 {
+		grid = 3
+	}
+	if core == 0 {
+		core = 1
 	}
-	pointWidth = 3
-	size = 3 * pointWidth * realSize
 
-	// Size of each module drawn.
-	widthPerModule := size / realSize
-	maskBlockWidth := pointWidth
-	offset := 0
+	if grid < minHalftoneGrid || grid > maxHalftoneGrid {
+		return 0, 0, fmt.Errorf("grid must be between %d and %d", minHalftoneGrid, maxHalftoneGrid)
+	} else if core < 1 || core > grid {
+		return 0, 0, errors.New("core size must be between 1 and the grid size")
+	}
 
-	// Init image
-	rect := image.Rectangle{Min: image.Point{0, 0}, Max: image.Point{size, size}}
-	img := image.NewRGBA(rect)
-	for i := 0; i < size; i++ {
-		for j := 0; j < size; j++ {
-			img.Set(i, j, q.option.BackgroundColor)
+	return grid, core, nil
+}
+
+// layout computes the geometry of the image of the code: the width of a
+// module, the padding around the modules and the width/height of the image.
+//
+// If the Size option is set, the module width is the largest fitting the image
+// and the remaining pixels are split between the sides. Otherwise every module
+// is made of blocks of pointWidth pixels.
+func (q *HalftoneQRCode) layout(pointWidth int) (moduleWidth int, padding int, size int, err error) {
+	numModules := q.symbol.size
+
+	grid, _, err := q.halftoneGrid()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if q.option.Size == 0 {
+		if pointWidth < 1 {
+			pointWidth = 1
 		}
+
+		moduleWidth = grid * pointWidth
+		return moduleWidth, 0, moduleWidth * numModules, nil
+	}
+
+	// Every block of a module must be at least a pixel wide.
+	moduleWidth = q.option.Size / numModules
+	if moduleWidth < grid {
+		return 0, 0, 0, fmt.Errorf("image size must be at least %d pixels", grid*numModules)
 	}
 
-	maskAreaImage, err := q.getMaskAreaImage(sourceImage, rect)
+	return moduleWidth, (q.option.Size - moduleWidth*numModules) / 2, q.option.Size, nil
+}
+
+// moduleCore returns the core of a module moduleWidth pixels wide, relative to
+// the module. The core is centered, so it holds the module center even when
+// it is not aligned with the blocks of the grid.
+func (q *HalftoneQRCode) moduleCore(moduleWidth int) image.Rectangle {
+	grid, core, _ := q.halftoneGrid()
+
+	offset := ((grid-core)*moduleWidth + grid) / (2 * grid)
+	if 2*offset >= moduleWidth {
+		offset = (moduleWidth - 1) / 2
+	}
+
+	return image.Rect(offset, offset, moduleWidth-offset, moduleWidth-offset)
+}
+
+func (q *HalftoneQRCode) drawCodeWithImage(pointWidth int, sourceImage image.Image) (image.Image, error) {
+	moduleWidth, padding, size, err := q.layout(pointWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.checkContrast(q.darkColors()...); err != nil {
+		return nil, err
+	}
+
+	// Init image
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	if !q.option.Transparent {
+		draw.Draw(img, img.Rect, image.NewUniform(q.option.BackgroundColor), image.Point{}, draw.Src)
+	}
+
+	codeWidth := moduleWidth * q.symbol.size
+	codeRect := image.Rect(padding, padding, padding+codeWidth, padding+codeWidth)
+
+	maskAreaImage, err := q.getMaskAreaImage(sourceImage, codeRect)
+	if err != nil {
+		return nil, err
+	}
+
+	showMask, err := q.maskedModules(sourceImage, maskAreaImage != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	colors, err := q.moduleColors(sourceImage)
 	if err != nil {
 		return nil, err
 	}
 
 	bitmap := q.symbol.bitmap()
+	core := q.moduleCore(moduleWidth)
+	z := vector.NewRasterizer(moduleWidth, moduleWidth)
 
-	// Start draw each pixel
+	// Start draw each module
 	for y, row := range bitmap {
 		for x, v := range row {
-			// calculate the start position of every module in image
-			startX := x*widthPerModule + offset - 1
-			startY := y*widthPerModule + offset - 1
-			// every module separated into nine blocks
+			light := q.lightColor(x, y)
+
+			moduleColor := light
+			if v && colors != nil {
+				moduleColor = colors[y][x]
+			} else if v {
+				moduleColor = q.option.ForegroundColor
+			}
+
+			module := image.Rect(0, 0, moduleWidth, moduleWidth).Add(codeRect.Min).
+				Add(image.Pt(x*moduleWidth, y*moduleWidth))
+
+			// Every module is split into a grid of blocks, 3x3 by default.
 			// 1 2 3
 			// 4 5 6
 			// 7 8 9
-			// If the module does not contains the special module, only the center block keep the foreground color, other
+			// If the module shows the mask image, only the core keep the module color, other
 			// set the pixel color with the maskImage's.
-			var countX, countY int
-			for i := startX; i < startX+widthPerModule; i += maskBlockWidth {
-				countY = 0
-				for j := startY; j < startY+widthPerModule; j += maskBlockWidth {
-					// draw each pixel of each separated block.
-					pixelX := i
-					pixelY := j
-					for itx := 0; itx <= maskBlockWidth; itx++ {
-						pixelX++
-						pixelY = j
-						for ity := 0; ity <= maskBlockWidth; ity++ {
-							pixelY++
-							if maskAreaImage != nil && (q.isDataModule(x, y) || !q.symbol.isUsed[y][x]) {
-								if !(countX == 1 && countY == 1) {
-									img.Set(pixelX, pixelY, maskAreaImage.At(pixelX, pixelY))
-									continue
-								}
-							}
-							if v {
-								img.Set(pixelX, pixelY, q.option.ForegroundColor)
-							} else if q.symbol.isUsed[y][x] {
-								img.Set(pixelX, pixelY, q.option.BackgroundColor)
-							}
-						}
-					}
-					countY++
+			shape := q.moduleShape(x, y)
+			if showMask[y][x] {
+				// Composite the mask image, which may be transparent, over the
+				// light colour.
+				draw.Draw(img, module, image.NewUniform(light), image.Point{}, draw.Src)
+				draw.Draw(img, module, maskAreaImage, module.Min.Sub(codeRect.Min), draw.Over)
+				if q.inQuietZone(x, y) {
+					draw.DrawMask(img, module, image.NewUniform(light), image.Point{}, quietZoneVeil, image.Point{},
+						draw.Over)
+				} else if shape == nil {
+					draw.Draw(img, core.Add(module.Min), image.NewUniform(moduleColor), image.Point{}, draw.Src)
+				} else {
+					drawModuleShape(img, z, core.Add(module.Min), moduleColor, shape, Neighbours{})
 				}
-				countX++
+			} else if shape == nil {
+				draw.Draw(img, module, image.NewUniform(moduleColor), image.Point{}, draw.Src)
+			} else {
+				draw.Draw(img, module, image.NewUniform(light), image.Point{}, draw.Src)
+				drawModuleShape(img, z, module, moduleColor, shape, joinedNeighbours(bitmap, showMask, x, y))
 			}
-
 		}
 	}
+	if q.option.Eyes != nil {
+		q.drawEyes(img, codeRect, moduleWidth)
+	}
+	if err := q.drawLogo(img, codeRect, moduleWidth); err != nil {
+		return nil, err
+	}
 	if q.option.Embed {
 		return q.embedCode(sourceImage, img), nil
 	}
 	return img, nil
 }
 
-// embedCode overlay the code on image
+// maskedModules returns which modules of the bitmap show the mask image around
+// their core: the data modules, the function patterns too if blended, or the
+// modules chosen by saliency, and the quiet zone with QuietZoneImage. None do
+// without mask image.
+func (q *HalftoneQRCode) maskedModules(sourceImage image.Image, hasMask bool) ([][]bool, error) {
+	if hasMask && q.option.Saliency {
+		return q.halftoneModules(sourceImage)
+	}
+
+	showMask := make([][]bool, q.symbol.size)
+	for y := range showMask {
+		showMask[y] = make([]bool, q.symbol.size)
+
+		for x := range showMask[y] {
+			switch {
+			case !hasMask:
+			case q.inQuietZone(x, y):
+				showMask[y][x] = q.option.QuietZoneImage
+			default:
+				showMask[y][x] = q.isDataModule(x, y) || q.option.BlendFunctionPatterns
+			}
+		}
+	}
+
+	return showMask, nil
+}
+
+// embedCode overlay the code on image, compositing with the alpha of both
 func (q *HalftoneQRCode) embedCode(dst image.Image, src image.Image) image.Image {
 	codeImage := imaging.Resize(src, q.option.MaskRectangle.Size().X, q.option.MaskRectangle.Size().Y, imaging.Lanczos)
-	return imaging.Overlay(dst, codeImage, q.option.MaskRectangle.Min, 1)
+
+	img := image.NewRGBA(dst.Bounds().Sub(dst.Bounds().Min))
+	draw.Draw(img, img.Rect, dst, dst.Bounds().Min, draw.Src)
+	draw.Draw(img, q.option.MaskRectangle.Sub(dst.Bounds().Min), codeImage, image.Point{}, draw.Over)
+
+	return img
 }
 
 func (q *HalftoneQRCode) isDataModule(x, y int) bool {
@@ -328,57 +750,143 @@ func (q *HalftoneQRCode) isDataModule(x, y int) bool {
 //
 // An error occurs if the content is too long.
 func NewHalftoneCode(content string, level RecoveryLevel) (*HalftoneQRCode, error) {
+	return NewHalftoneCodeWithOption(content, level, EncodeOption{})
+}
+
+// NewHalftoneCodeWithOption constructs a basic QRCode, encoding the content
+// according to opt.
+//
+//	q, err := qart.NewHalftoneCodeWithOption("Grüße", qart.Medium,
+//		qart.EncodeOption{Charset: qart.CharsetUTF8})
+//
+// A *CapacityError is returned if the content is too long for the allowed
+// versions.
+func NewHalftoneCodeWithOption(content string, level RecoveryLevel, opt EncodeOption) (*HalftoneQRCode, error) {
+	return newHalftoneCode(content, level, opt, nil)
+}
+
+// newHalftoneCode constructs a QRCode, sa is the Structured Append header of the
+// symbol or nil.
+func newHalftoneCode(content string, level RecoveryLevel, opt EncodeOption, sa *structuredAppend) (*HalftoneQRCode, error) {
+	encoder, encoded, chosenVersion, err := encodingChooser(opt)(content, level, opt, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &HalftoneQRCode{
+		Content: content,
+
+		Level:         chosenVersion.level,
+		VersionNumber: chosenVersion.version,
+		option: &Option{
+			ForegroundColor: color.Black,
+			BackgroundColor: color.White,
+		},
+
+		encoder: encoder,
+		data:    encoded,
+		version: *chosenVersion,
+	}
+
+	if opt.LogoSize != 0 {
+		q.logoArea = logoArea(*chosenVersion, opt.LogoSize)
+	}
+
+	q.encode(chosenVersion.numTerminatorBitsRequired(encoded.Len()), opt)
+
+	return q, nil
+}
+
+// encodingChooser returns the function choosing the encoding of a symbol:
+// chooseLogoEncoding if opt reserves a logo, chooseEncoding otherwise.
+func encodingChooser(opt EncodeOption) func(string, RecoveryLevel, EncodeOption,
+	*structuredAppend) (*dataEncoder, *bitset.Bitset, *qrCodeVersion, error) {
+	if opt.LogoSize != 0 {
+		return chooseLogoEncoding
+	}
+	return chooseEncoding
+}
+
+// chooseEncoding encodes content and chooses the smallest QR Code version
+// allowed by opt able to hold the encoded data.
+//
+// A *CapacityError is returned if the content is too long.
+func chooseEncoding(content string, level RecoveryLevel, opt EncodeOption, sa *structuredAppend) (*dataEncoder, *bitset.Bitset, *qrCodeVersion, error) {
+	minVersion, maxVersion, err := opt.versionRange()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if opt.ForceMask && (opt.Mask < 0 || opt.Mask > 7) {
+		return nil, nil, nil, ErrInvalidMask
+	}
+
 	encoders := []dataEncoderType{dataEncoderType1To9, dataEncoderType10To26,
 		dataEncoderType27To40}
 
 	var encoder *dataEncoder
 	var encoded *bitset.Bitset
 	var chosenVersion *qrCodeVersion
-	var err error
 
 	for _, t := range encoders {
 		encoder = newDataEncoder(t)
+		if encoder.maxVersion < minVersion || encoder.minVersion > maxVersion {
+			continue
+		}
+
+		encoder.structuredAppend = sa
+		encoder.charset = opt.Charset
+		encoder.fnc1 = opt.FNC1
+		encoder.applicationIndicator = opt.ApplicationIndicator
 		encoded, err = encoder.encode([]byte(content))
 
 		if err != nil {
 			continue
 		}
 
-		chosenVersion = chooseQRCodeVersion(level, encoder, encoded.Len())
+		chosenVersion = chooseQRCodeVersion(level, encoder, encoded.Len(), minVersion)
+
+		if chosenVersion != nil && chosenVersion.version > maxVersion {
+			chosenVersion = nil
+		}
 
 		if chosenVersion != nil {
 			break
 		}
 	}
 
-	if err != nil {
-		return nil, err
+	if err != nil && err != errLengthTooLong {
+		return nil, nil, nil, err
 	} else if chosenVersion == nil {
-		return nil, errors.New("content too long to encode")
+		return nil, nil, nil, &CapacityError{Level: level, MinVersion: minVersion, MaxVersion: maxVersion}
 	}
 
-	q := &HalftoneQRCode{
-		Content: content,
+	if opt.BoostLevel {
+		chosenVersion = boostLevel(chosenVersion, encoded.Len())
+	}
 
-		Level:         level,
-		VersionNumber: chosenVersion.version,
-		option: &Option{
-			ForegroundColor: color.Black,
-			BackgroundColor: color.White,
-		},
+	return encoder, encoded, chosenVersion, nil
+}
 
-		encoder: encoder,
-		data:    encoded,
-		version: *chosenVersion,
-	}
+// boostLevel returns the version with the same version number as v and the
+// highest error recovery level able to hold numDataBits.
+func boostLevel(v *qrCodeVersion, numDataBits int) *qrCodeVersion {
+	for level := Highest; level > v.level; level-- {
+		boosted := getQRCodeVersion(level, v.version)
 
-	q.encode(chosenVersion.numTerminatorBitsRequired(encoded.Len()))
+		if boosted != nil && boosted.numDataBits() >= numDataBits {
+			return boosted
+		}
+	}
 
-	return q, nil
+	return v
 }
 
-func (q *HalftoneQRCode) encode(numTerminatorBits int) {
+// encode builds the symbol from the encoded data, using the data mask pattern
+// with the lowest penalty score unless opt forces a mask.
+func (q *HalftoneQRCode) encode(numTerminatorBits int, opt EncodeOption) {
 	q.addTerminatorBits(numTerminatorBits)
+	q.numContentBits = q.data.Len()
 	q.addPadding()
 
 	encoded := q.encodeBlocks()
@@ -387,6 +895,10 @@ func (q *HalftoneQRCode) encode(numTerminatorBits int) {
 	penalty := 0
 
 	for mask := 0; mask < numMasks; mask++ {
+		if opt.ForceMask && mask != opt.Mask {
+			continue
+		}
+
 		var s *HalftoneSymbol
 		var err error
 
@@ -4,6 +4,9 @@
 package qart
 
 import (
+	"bytes"
+	"image"
+	"image/color"
 	"strings"
 	"testing"
 )
@@ -156,6 +159,10 @@ func TestHalftoneQRCodeVersionCapacity(t *testing.T) {
 			t.Fatalf("Test #%d byte has version #%d, expected #%d", i,
 				b.VersionNumber, test.version)
 		}
+
+		checkRoundTrip(t, n)
+		checkRoundTrip(t, a)
+		checkRoundTrip(t, b)
 	}
 }
 
@@ -174,6 +181,326 @@ func TestHalftoneQRCodeISOAnnexIExample(t *testing.T) {
 		t.Errorf("ISO Annex I example mask got %d, expected %d\n", q.mask,
 			expectedMask)
 	}
+
+	checkRoundTrip(t, q)
+}
+
+func TestHalftoneQRCodeKanji(t *testing.T) {
+	// 20 x Shift JIS "点" fits version 2-L in Kanji mode (272 bits) but needs
+	// version 3 in byte mode (332 bits).
+	content := strings.Repeat("\x93\x5f", 20)
+
+	q, err := NewHalftoneCode(content, Low)
+	if err != nil {
+		t.Fatalf("Error encoding Kanji content: %s, expected success", err.Error())
+	}
+
+	if q.VersionNumber != 2 {
+		t.Errorf("Kanji content has version #%d, expected #%d", q.VersionNumber, 2)
+	}
+
+	checkRoundTrip(t, q)
+}
+
+func TestHalftoneQRCodeVersionOption(t *testing.T) {
+	tests := []struct {
+		content  string
+		opt      EncodeOption
+		expected int
+	}{
+		{"hello", EncodeOption{Version: 10}, 10},
+		{"hello", EncodeOption{Version: 40}, 40},
+		{"hello", EncodeOption{MinVersion: 5}, 5},
+		// The content needs version 8-M, above the minimum version.
+		{strings.Repeat("#", 150), EncodeOption{MinVersion: 5}, 8},
+		{strings.Repeat("#", 150), EncodeOption{}, 8},
+	}
+
+	for _, test := range tests {
+		q, err := NewHalftoneCodeWithOption(test.content, Medium, test.opt)
+		if err != nil {
+			t.Errorf("%+v got %s, expected success", test.opt, err.Error())
+			continue
+		}
+
+		if q.VersionNumber != test.expected {
+			t.Errorf("%+v has version #%d, expected #%d", test.opt, q.VersionNumber,
+				test.expected)
+		}
+
+		checkRoundTrip(t, q)
+	}
+
+	_, err := NewHalftoneCodeWithOption(strings.Repeat("#", 150), Medium, EncodeOption{Version: 7})
+	if e, ok := err.(*CapacityError); !ok {
+		t.Errorf("got %v, expected *CapacityError", err)
+	} else if e.MinVersion != 7 || e.MaxVersion != 7 || e.Level != Medium {
+		t.Errorf("got %+v, expected version 7-M", e)
+	}
+
+	invalid := []struct {
+		opt      EncodeOption
+		expected error
+	}{
+		{EncodeOption{Version: 41}, ErrInvalidVersion},
+		{EncodeOption{MinVersion: -1}, ErrInvalidVersion},
+		{EncodeOption{Version: 3, MinVersion: 5}, ErrInvalidVersion},
+		{EncodeOption{ForceMask: true, Mask: 8}, ErrInvalidMask},
+	}
+
+	for _, test := range invalid {
+		if _, err := NewHalftoneCodeWithOption("hello", Medium, test.opt); err != test.expected {
+			t.Errorf("%+v got %v, expected %v", test.opt, err, test.expected)
+		}
+	}
+}
+
+func TestHalftoneQRCodeForceMask(t *testing.T) {
+	for mask := 0; mask < 8; mask++ {
+		q, err := NewHalftoneCodeWithOption("01234567", Medium, EncodeOption{ForceMask: true, Mask: mask})
+		if err != nil {
+			t.Fatalf("mask %d got %s, expected success", mask, err.Error())
+		}
+
+		if q.mask != mask {
+			t.Errorf("got mask %d, expected %d", q.mask, mask)
+		}
+
+		checkRoundTrip(t, q)
+	}
+}
+
+func TestHalftoneQRCodeBoostLevel(t *testing.T) {
+	tests := []struct {
+		content         string
+		level           RecoveryLevel
+		expectedVersion int
+		expectedLevel   RecoveryLevel
+	}{
+		// 1-H holds 7 bytes.
+		{"hello", Low, 1, Highest},
+		// 2-L is required, 2-M holds 26 bytes but 2-Q only 20.
+		{strings.Repeat("#", 21), Low, 2, Medium},
+		// The requested level is never lowered.
+		{strings.Repeat("#", 21), Highest, 3, Highest},
+	}
+
+	for _, test := range tests {
+		q, err := NewHalftoneCodeWithOption(test.content, test.level, EncodeOption{BoostLevel: true})
+		if err != nil {
+			t.Errorf("%q got %s, expected success", test.content, err.Error())
+			continue
+		}
+
+		if q.VersionNumber != test.expectedVersion || q.Level != test.expectedLevel {
+			t.Errorf("%q got version %d level %d, expected version %d level %d", test.content,
+				q.VersionNumber, q.Level, test.expectedVersion, test.expectedLevel)
+		}
+
+		checkRoundTrip(t, q)
+	}
+}
+
+// checkModulePixels checks every pixel of img has the colour of the module of
+// q it is in, modules being moduleWidth pixels wide after padding pixels.
+func checkModulePixels(t *testing.T, q *HalftoneQRCode, img image.Image, moduleWidth int, padding int) {
+	bitmap := q.Bitmap()
+	codeWidth := moduleWidth * len(bitmap)
+
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			expected := color.White
+			if x >= padding && y >= padding && x < padding+codeWidth && y < padding+codeWidth &&
+				bitmap[(y-padding)/moduleWidth][(x-padding)/moduleWidth] {
+				expected = color.Black
+			}
+
+			if r, g, b, _ := img.At(x, y).RGBA(); r != uint32(expected.Y) || g != r || b != r {
+				t.Fatalf("pixel (%d, %d) got %v, expected %v", x, y, img.At(x, y), expected)
+			}
+		}
+	}
+}
+
+func TestHalftoneQRCodePointWidth(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 and the quiet zones are 29 modules wide.
+	for _, pointWidth := range []int{0, 1, 2, 5} {
+		img, err := q.CodeImage(pointWidth)
+		if err != nil {
+			t.Fatalf("pointWidth %d got %s, expected success", pointWidth, err.Error())
+		}
+
+		moduleWidth := 3 * pointWidth
+		if pointWidth == 0 {
+			moduleWidth = 3
+		}
+
+		if size := img.Bounds().Size(); size != image.Pt(29*moduleWidth, 29*moduleWidth) {
+			t.Errorf("pointWidth %d got size %v, expected %d pixels", pointWidth, size, 29*moduleWidth)
+			continue
+		}
+
+		checkModulePixels(t, q, img, moduleWidth, 0)
+	}
+}
+
+func TestHalftoneQRCodeSize(t *testing.T) {
+	tests := []struct {
+		size        int
+		moduleWidth int
+		padding     int
+	}{
+		{87, 3, 0},
+		{100, 3, 6},
+		{1024, 35, 4},
+		{1025, 35, 5},
+	}
+
+	for _, test := range tests {
+		q, err := NewHalftoneCode("hello", Highest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q.AddOption(Option{Size: test.size})
+
+		// pointWidth is ignored.
+		img, err := q.CodeImage(5)
+		if err != nil {
+			t.Fatalf("size %d got %s, expected success", test.size, err.Error())
+		}
+
+		if size := img.Bounds().Size(); size != image.Pt(test.size, test.size) {
+			t.Errorf("got size %v, expected %d pixels", size, test.size)
+			continue
+		}
+
+		checkModulePixels(t, q, img, test.moduleWidth, test.padding)
+
+		q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+
+		img, err = q.CodeImage(5)
+		if err != nil {
+			t.Fatalf("size %d got %s, expected success", test.size, err.Error())
+		}
+
+		if _, err := q.Verify(img); err != nil {
+			t.Errorf("size %d got %s, expected success", test.size, err.Error())
+		}
+	}
+
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{Size: 86})
+
+	if _, err := q.CodeImage(1); err == nil {
+		t.Error("got success, expected error")
+	}
+}
+
+func TestHalftoneQRCodeGrid(t *testing.T) {
+	tests := []struct {
+		grid        int
+		coreSize    int
+		moduleWidth int
+		core        image.Rectangle
+	}{
+		{0, 0, 6, image.Rect(2, 2, 4, 4)},
+		{2, 1, 4, image.Rect(1, 1, 3, 3)},
+		{4, 2, 8, image.Rect(2, 2, 6, 6)},
+		{5, 1, 10, image.Rect(4, 4, 6, 6)},
+		{5, 2, 10, image.Rect(3, 3, 7, 7)},
+		{7, 3, 14, image.Rect(4, 4, 10, 10)},
+		{7, 7, 14, image.Rect(0, 0, 14, 14)},
+	}
+
+	for _, test := range tests {
+		q, err := NewHalftoneCode("hello", Highest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q.AddOption(Option{
+			MaskImageFile: bytes.NewReader(testMaskImage()),
+			Grid:          test.grid,
+			CoreSize:      test.coreSize,
+		})
+
+		img, err := q.CodeImage(2)
+		if err != nil {
+			t.Fatalf("grid %d core %d got %s, expected success", test.grid, test.coreSize, err.Error())
+		}
+
+		if size := img.Bounds().Dx(); size != 29*test.moduleWidth {
+			t.Errorf("grid %d core %d got size %d, expected %d", test.grid, test.coreSize, size,
+				29*test.moduleWidth)
+			continue
+		}
+
+		if core := q.moduleCore(test.moduleWidth); core != test.core {
+			t.Errorf("grid %d core %d got core %v, expected %v", test.grid, test.coreSize, core, test.core)
+		}
+
+		if _, err := q.Verify(img); err != nil {
+			t.Errorf("grid %d core %d got %s, expected success", test.grid, test.coreSize, err.Error())
+		}
+	}
+
+	for _, opt := range []Option{{Grid: 1}, {Grid: 8}, {Grid: 3, CoreSize: 4}, {CoreSize: -1}} {
+		q, err := NewHalftoneCode("hello", Highest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q.AddOption(opt)
+
+		if _, err := q.CodeImage(2); err == nil {
+			t.Errorf("grid %d core %d got success, expected error", opt.Grid, opt.CoreSize)
+		}
+	}
+}
+
+func TestHalftoneQRCodeBlendFunctionPatterns(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+
+	// The top left corner of the finder pattern, after a quiet zone of 4
+	// modules.
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, g, b, _ := img.At(36, 36).RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Errorf("got %v, expected a solid finder pattern", img.At(36, 36))
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage()), BlendFunctionPatterns: true})
+
+	img, err = q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, g, b, _ := img.At(36, 36).RGBA(); r == 0 && g == 0 && b == 0 {
+		t.Errorf("got %v, expected the mask image", img.At(36, 36))
+	}
+
+	if r, g, b, _ := img.At(40, 40).RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Errorf("got %v, expected a dark core", img.At(40, 40))
+	}
 }
 
 func BenchmarkHalftoneQRCodeURLSize(b *testing.B) {
@@ -1,6 +1,10 @@
 package qart
 
-import "github.com/xrlin/qart/bitset"
+import (
+	"image"
+
+	"github.com/xrlin/qart/bitset"
+)
 
 type HalftoneRegularSymbol struct {
 	version qrCodeVersion
@@ -108,38 +112,32 @@ var (
 )
 
 func (m *HalftoneRegularSymbol) addData() (bool, error) {
+	m.walkDataModules(m.data.Len(), func(i int, x int, y int) {
+		// != is equivalent to XOR.
+		m.symbol.set(x, y, dataMask(m.mask, x, y) != m.data.At(i))
+		m.symbol.markDataModule(x, y)
+	})
+
+	return true, nil
+}
+
+// walkDataModules calls fn with the coordinates of the first n data modules,
+// in the order the data bits are placed in the symbol.
+//
+// The data is placed in two module wide columns, zigzagging upwards and
+// downwards from the bottom right corner of the symbol and skipping the modules
+// already set by function patterns.
+func (m *HalftoneRegularSymbol) walkDataModules(n int, fn func(i int, x int, y int)) {
 	xOffset := 1
 	dir := up
 
 	x := m.size - 2
 	y := m.size - 1
 
-	for i := 0; i < m.data.Len(); i++ {
-		var mask bool
-		switch m.mask {
-		case 0:
-			mask = (y+x+xOffset)%2 == 0
-		case 1:
-			mask = y%2 == 0
-		case 2:
-			mask = (x+xOffset)%3 == 0
-		case 3:
-			mask = (y+x+xOffset)%3 == 0
-		case 4:
-			mask = (y/2+(x+xOffset)/3)%2 == 0
-		case 5:
-			mask = (y*(x+xOffset))%2+(y*(x+xOffset))%3 == 0
-		case 6:
-			mask = ((y*(x+xOffset))%2+((y*(x+xOffset))%3))%2 == 0
-		case 7:
-			mask = ((y+x+xOffset)%2+((y*(x+xOffset))%3))%2 == 0
-		}
-
-		// != is equivalent to XOR.
-		m.symbol.set(x+xOffset, y, mask != m.data.At(i))
-		m.symbol.markDataModule(x+xOffset, y)
+	for i := 0; i < n; i++ {
+		fn(i, x+xOffset, y)
 
-		if i == m.data.Len()-1 {
+		if i == n-1 {
 			break
 		}
 
@@ -177,19 +175,55 @@ func (m *HalftoneRegularSymbol) addData() (bool, error) {
 			}
 		}
 	}
+}
 
-	return true, nil
+// dataMask returns true if the data mask pattern mask inverts the module at
+// (x, y).
+func dataMask(mask int, x int, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+((y*x)%3))%2 == 0
+	case 7:
+		return ((y+x)%2+((y*x)%3))%2 == 0
+	}
+
+	return false
 }
 
 func buildHalftoneRegularSymbol(version qrCodeVersion, mask int,
 	data *bitset.Bitset) (*HalftoneSymbol, error) {
+	m := newHalftoneRegularSymbol(version, mask)
+	m.data = data
+
+	ok, err := m.addData()
+	if !ok {
+		return nil, err
+	}
+
+	return m.symbol, nil
+}
+
+// newHalftoneRegularSymbol constructs a symbol with its function patterns set,
+// leaving the data modules empty.
+func newHalftoneRegularSymbol(version qrCodeVersion, mask int) *HalftoneRegularSymbol {
 	m := &HalftoneRegularSymbol{
 		version: version,
 		mask:    mask,
-		data:    data,
 
 		size:   version.symbolSize(),
-		symbol: newHalftoneSymbol(version.symbolSize(), 1),
+		symbol: newHalftoneSymbol(version.symbolSize(), version.quietZoneSize()),
 	}
 
 	m.addFinderPatterns()
@@ -198,12 +232,7 @@ func buildHalftoneRegularSymbol(version qrCodeVersion, mask int,
 	m.addFormatInfo()
 	m.addVersionInfo()
 
-	ok, err := m.addData()
-	if !ok {
-		return nil, err
-	}
-
-	return m.symbol, nil
+	return m
 }
 
 func (m *HalftoneRegularSymbol) addFinderPatterns() {
@@ -226,6 +255,12 @@ func (m *HalftoneRegularSymbol) addFinderPatterns() {
 	m.symbol.set2dPattern(0, m.size-fpSize, fp)
 	m.symbol.set2dPattern(0, m.size-fpSize-1, fpHBorder)
 	m.symbol.set2dPattern(fpSize, m.size-fpSize-1, fpVBorder)
+
+	m.symbol.finderPatterns = []image.Rectangle{
+		m.symbol.patternRect(0, 0, fpSize),
+		m.symbol.patternRect(m.size-fpSize, 0, fpSize),
+		m.symbol.patternRect(0, m.size-fpSize, fpSize),
+	}
 }
 
 func (m *HalftoneRegularSymbol) addAlignmentPatterns() {
@@ -236,6 +271,8 @@ func (m *HalftoneRegularSymbol) addAlignmentPatterns() {
 			}
 
 			m.symbol.set2dPattern(x-2, y-2, alignmentPattern)
+			m.symbol.alignmentPatterns = append(m.symbol.alignmentPatterns,
+				m.symbol.patternRect(x-2, y-2, len(alignmentPattern)))
 		}
 	}
 }
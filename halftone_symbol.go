@@ -1,5 +1,7 @@
 package qart
 
+import "image"
+
 type HalftoneSymbol struct {
 	// Value of module at [y][x]. True is set.
 	module [][]bool
@@ -20,6 +22,11 @@ type HalftoneSymbol struct {
 	quietZoneSize int
 
 	dataModule [][]bool
+
+	// Modules covered by the finder and alignment patterns, including the
+	// quiet zone in the coordinates.
+	finderPatterns    []image.Rectangle
+	alignmentPatterns []image.Rectangle
 }
 
 // Constants used to weight penalty calculations. Specified by ISO/IEC
@@ -53,10 +60,43 @@ func newHalftoneSymbol(size int, quietZoneSize int) *HalftoneSymbol {
 	return &m
 }
 
+// withQuietZone returns a copy of the symbol with a quiet zone of
+// quietZoneSize modules.
+func (m *HalftoneSymbol) withQuietZone(quietZoneSize int) *HalftoneSymbol {
+	s := newHalftoneSymbol(m.symbolSize, quietZoneSize)
+
+	for y := 0; y < m.symbolSize; y++ {
+		for x := 0; x < m.symbolSize; x++ {
+			sx, sy := x+quietZoneSize, y+quietZoneSize
+			mx, my := x+m.quietZoneSize, y+m.quietZoneSize
+
+			s.module[sy][sx] = m.module[my][mx]
+			s.isUsed[sy][sx] = m.isUsed[my][mx]
+			s.dataModule[sy][sx] = m.dataModule[my][mx]
+		}
+	}
+
+	offset := image.Pt(quietZoneSize-m.quietZoneSize, quietZoneSize-m.quietZoneSize)
+	for _, r := range m.finderPatterns {
+		s.finderPatterns = append(s.finderPatterns, r.Add(offset))
+	}
+	for _, r := range m.alignmentPatterns {
+		s.alignmentPatterns = append(s.alignmentPatterns, r.Add(offset))
+	}
+
+	return s
+}
+
 func (m *HalftoneSymbol) markDataModule(x int, y int) {
 	m.dataModule[y+m.quietZoneSize][x+m.quietZoneSize] = true
 }
 
+// patternRect returns the modules covered by a pattern of size*size at (x, y),
+// including the quiet zone in the coordinates.
+func (m *HalftoneSymbol) patternRect(x int, y int, size int) image.Rectangle {
+	return image.Rect(x, y, x+size, y+size).Add(image.Pt(m.quietZoneSize, m.quietZoneSize))
+}
+
 // get returns the module value at (x, y).
 func (m *HalftoneSymbol) get(x int, y int) (v bool) {
 	v = m.module[y+m.quietZoneSize][x+m.quietZoneSize]
@@ -0,0 +1,675 @@
+package qart
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Image decoding.
+//
+// DecodeImage reads a QR Code from a picture in four steps:
+//
+// - The image is binarized with an adaptive threshold: each pixel is compared
+// with the mean luminance of its neighbourhood, so that halftone codes whose
+// modules are surrounded by image pixels keep their dark and light modules.
+// - The three finder patterns are located by scanning for runs of dark and
+// light pixels in the 1:1:3:1:1 ratio, cross-checked vertically and
+// horizontally.
+// - The module size and symbol dimension are estimated from the finder
+// patterns. For version 2 and above the bottom right alignment pattern is
+// searched to correct perspective distortion.
+// - Every module is sampled at its center through the perspective transform,
+// and the resulting module matrix is decoded by decodeModules.
+
+// DecodeImage locates, samples and decodes the QR Code in img, such as the
+// images produced by CodeImage and the frames produced by CodeGif.
+//
+// An error occurs if no QR Code is found, or if it cannot be decoded.
+func DecodeImage(img image.Image) (*DecodedCode, error) {
+	b := binarize(img)
+
+	tl, tr, bl, err := selectFinderPatterns(findFinderPatterns(b))
+	if err != nil {
+		return nil, err
+	}
+
+	moduleSize := (tl.moduleSize + tr.moduleSize + bl.moduleSize) / 3
+
+	for _, dimension := range estimateDimensions(tl, tr, bl, moduleSize) {
+		transform := gridTransform(b, tl, tr, bl, moduleSize, dimension)
+
+		var result *DecodedCode
+		result, err = decodeModules(sampleGrid(b, transform, dimension))
+		if err == nil {
+			return result, nil
+		}
+	}
+
+	return nil, err
+}
+
+// binaryImage is a binarized image, dark[y*width+x] is true if the pixel at
+// (x, y) is dark.
+type binaryImage struct {
+	width  int
+	height int
+	dark   []bool
+}
+
+// at returns true if the pixel at (x, y) is dark. Pixels outside of the image
+// are light.
+func (b *binaryImage) at(x int, y int) bool {
+	if x < 0 || y < 0 || x >= b.width || y >= b.height {
+		return false
+	}
+
+	return b.dark[y*b.width+x]
+}
+
+// binarize converts img to a binaryImage.
+//
+// Transparent pixels are composited over white. A pixel is dark if its
+// luminance is below the mean luminance of the surrounding window, a quarter
+// of the image wide.
+func binarize(img image.Image) *binaryImage {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	lum := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			lum[y*w+x] = luminance(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	// integral[(y+1)*(w+1)+x+1] is the sum of lum over [0, x] x [0, y].
+	integral := make([]int, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		rowSum := 0
+		for x := 0; x < w; x++ {
+			rowSum += lum[y*w+x]
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + rowSum
+		}
+	}
+
+	radius := w
+	if h > radius {
+		radius = h
+	}
+	radius /= 8
+	if radius < 4 {
+		radius = 4
+	}
+
+	b := &binaryImage{width: w, height: h, dark: make([]bool, w*h)}
+
+	for y := 0; y < h; y++ {
+		y0, y1 := clamp(y-radius, 0, h), clamp(y+radius+1, 0, h)
+
+		for x := 0; x < w; x++ {
+			x0, x1 := clamp(x-radius, 0, w), clamp(x+radius+1, 0, w)
+
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] -
+				integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			mean := sum / ((x1 - x0) * (y1 - y0))
+
+			// A small margin keeps uniform areas light.
+			b.dark[y*w+x] = lum[y*w+x]+4 <= mean
+		}
+	}
+
+	return b
+}
+
+// luminance returns the luminance (0-255) of c composited over white.
+func luminance(c color.Color) int {
+	r, g, b, a := c.RGBA()
+
+	// Composite the premultiplied colour over white.
+	r += 0xffff - a
+	g += 0xffff - a
+	b += 0xffff - a
+
+	return int((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+}
+
+func clamp(v int, min int, max int) int {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+
+	return v
+}
+
+// finderCandidate is the estimated center of a finder pattern.
+type finderCandidate struct {
+	x float64
+	y float64
+
+	// Estimated module size in pixels.
+	moduleSize float64
+
+	// Number of scan lines the pattern was found on.
+	count int
+}
+
+// findFinderPatterns scans every row of b for finder patterns.
+func findFinderPatterns(b *binaryImage) []*finderCandidate {
+	var candidates []*finderCandidate
+
+	for y := 0; y < b.height; y++ {
+		var counts [5]int
+		state := 0
+
+		for x := 0; x <= b.width; x++ {
+			// The pixel past the end of the row is light, terminating any pattern.
+			dark := x < b.width && b.at(x, y)
+
+			if dark {
+				if state&1 == 1 {
+					state++
+				}
+				counts[state]++
+				continue
+			}
+
+			if state&1 == 1 {
+				counts[state]++
+				continue
+			}
+
+			if state != 4 {
+				state++
+				counts[state]++
+				continue
+			}
+
+			if isFinderRatio(counts) {
+				if c := b.finderCenter(counts, x, y); c != nil {
+					candidates = mergeCandidate(candidates, c)
+				}
+			}
+
+			// Keep the last dark-light-dark runs, they may start a pattern.
+			counts = [5]int{counts[2], counts[3], counts[4], 1, 0}
+			state = 3
+		}
+	}
+
+	return candidates
+}
+
+// isFinderRatio returns true if counts are runs of pixels in the 1:1:3:1:1
+// ratio of a finder pattern.
+func isFinderRatio(counts [5]int) bool {
+	total := 0
+	for _, c := range counts {
+		if c == 0 {
+			return false
+		}
+
+		total += c
+	}
+
+	if total < 7 {
+		return false
+	}
+
+	moduleSize := float64(total) / 7
+	maxVariance := moduleSize / 2
+
+	return math.Abs(moduleSize-float64(counts[0])) < maxVariance &&
+		math.Abs(moduleSize-float64(counts[1])) < maxVariance &&
+		math.Abs(3*moduleSize-float64(counts[2])) < 3*maxVariance &&
+		math.Abs(moduleSize-float64(counts[3])) < maxVariance &&
+		math.Abs(moduleSize-float64(counts[4])) < maxVariance
+}
+
+// isAlignmentRatio returns true if counts are runs of pixels in the 1:1:1
+// ratio of the center of an alignment pattern, for modules of moduleSize.
+func isAlignmentRatio(counts [3]int, moduleSize float64) bool {
+	for _, c := range counts {
+		if math.Abs(moduleSize-float64(c)) >= moduleSize/2 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// finderCenter cross-checks the horizontal finder pattern runs counts ending at
+// (end, y) vertically and horizontally, and returns the center of the pattern,
+// or nil.
+func (b *binaryImage) finderCenter(counts [5]int, end int, y int) *finderCandidate {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+
+	centerX := float64(end-counts[4]-counts[3]) - float64(counts[2])/2
+
+	verticalCounts, centerY, ok := b.crossCheck(int(centerX), y, 0, 1, total)
+	if !ok {
+		return nil
+	}
+
+	horizontalCounts, centerX, ok := b.crossCheck(int(centerX), int(centerY), 1, 0, total)
+	if !ok {
+		return nil
+	}
+
+	return &finderCandidate{
+		x:          centerX,
+		y:          centerY,
+		moduleSize: float64(verticalCounts+horizontalCounts) / 14,
+		count:      1,
+	}
+}
+
+// crossCheck counts the finder pattern runs through (x, y) in the direction
+// (dx, dy). It returns the total length of the runs and the coordinate of the
+// pattern center along the direction, if the runs are in the finder pattern
+// ratio and about as long as originalTotal.
+func (b *binaryImage) crossCheck(x int, y int, dx int, dy int, originalTotal int) (int, float64, bool) {
+	if !b.at(x, y) {
+		return 0, 0, false
+	}
+
+	var counts [5]int
+
+	// Count backwards from the center: dark center, light ring, dark ring.
+	i := 0
+	for state := 2; state >= 0; state-- {
+		for b.at(x-i*dx, y-i*dy) == (state != 1) && i <= originalTotal {
+			counts[state]++
+			i++
+		}
+	}
+
+	// Count forwards from the center.
+	j := 1
+	for state := 2; state <= 4; state++ {
+		for b.at(x+j*dx, y+j*dy) == (state != 3) && j <= originalTotal {
+			counts[state]++
+			j++
+		}
+	}
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+
+	if 5*abs(total-originalTotal) >= 2*originalTotal || !isFinderRatio(counts) {
+		return 0, 0, false
+	}
+
+	// The pattern ends j-1 pixels after the start coordinate.
+	start := x
+	if dy != 0 {
+		start = y
+	}
+	end := float64(start + j)
+
+	return total, end - float64(counts[4]+counts[3]) - float64(counts[2])/2, true
+}
+
+// mergeCandidate adds c to candidates, averaging it with an existing candidate
+// for the same pattern.
+func mergeCandidate(candidates []*finderCandidate, c *finderCandidate) []*finderCandidate {
+	for _, existing := range candidates {
+		if math.Abs(c.x-existing.x) <= existing.moduleSize &&
+			math.Abs(c.y-existing.y) <= existing.moduleSize &&
+			math.Abs(c.moduleSize-existing.moduleSize) <= math.Max(1, existing.moduleSize) {
+			n := float64(existing.count)
+
+			existing.x = (existing.x*n + c.x) / (n + 1)
+			existing.y = (existing.y*n + c.y) / (n + 1)
+			existing.moduleSize = (existing.moduleSize*n + c.moduleSize) / (n + 1)
+			existing.count++
+
+			return candidates
+		}
+	}
+
+	return append(candidates, c)
+}
+
+// selectFinderPatterns chooses the three candidates most likely to be the
+// finder patterns of a QR Code, and returns them as the top left, top right
+// and bottom left patterns.
+func selectFinderPatterns(candidates []*finderCandidate) (tl, tr, bl *finderCandidate, err error) {
+	// Prefer the candidates confirmed by several scan lines.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].count > candidates[j].count
+	})
+
+	confirmed := candidates
+	for i, c := range candidates {
+		if c.count < 2 {
+			confirmed = candidates[:i]
+			break
+		}
+	}
+
+	if len(confirmed) >= 3 {
+		candidates = confirmed
+	}
+
+	if len(candidates) < 3 {
+		return nil, nil, nil, errors.New("no QR Code found in image")
+	}
+
+	if len(candidates) > 10 {
+		candidates = candidates[:10]
+	}
+
+	bestScore := math.Inf(1)
+
+	for i := 0; i < len(candidates); i++ {
+		for j := i + 1; j < len(candidates); j++ {
+			for k := j + 1; k < len(candidates); k++ {
+				a, b, c := candidates[i], candidates[j], candidates[k]
+
+				// The three patterns form an isosceles right triangle and have the
+				// same module size.
+				sizes := []float64{a.moduleSize, b.moduleSize, c.moduleSize}
+				sort.Float64s(sizes)
+				if sizes[2] > 1.5*sizes[0] {
+					continue
+				}
+
+				d := []float64{distance(a, b), distance(b, c), distance(a, c)}
+				sort.Float64s(d)
+
+				score := math.Abs(d[2]*d[2]-d[0]*d[0]-d[1]*d[1])/(d[2]*d[2]) +
+					math.Abs(d[1]-d[0])/d[1] + (sizes[2]-sizes[0])/sizes[2]
+
+				if score < bestScore {
+					bestScore = score
+					tl, tr, bl = orderFinderPatterns(a, b, c)
+				}
+			}
+		}
+	}
+
+	if tl == nil || bestScore > 0.5 {
+		return nil, nil, nil, errors.New("no QR Code found in image")
+	}
+
+	return tl, tr, bl, nil
+}
+
+// orderFinderPatterns orders the three finder patterns a, b and c as the top
+// left, top right and bottom left patterns.
+func orderFinderPatterns(a, b, c *finderCandidate) (tl, tr, bl *finderCandidate) {
+	// The top left pattern is opposite the longest side.
+	switch ab, bc, ac := distance(a, b), distance(b, c), distance(a, c); {
+	case bc >= ab && bc >= ac:
+		tl, tr, bl = a, b, c
+	case ac >= ab && ac >= bc:
+		tl, tr, bl = b, a, c
+	default:
+		tl, tr, bl = c, a, b
+	}
+
+	// With y pointing down, the top right pattern is clockwise from the bottom
+	// left pattern.
+	if (tr.x-tl.x)*(bl.y-tl.y)-(tr.y-tl.y)*(bl.x-tl.x) < 0 {
+		tr, bl = bl, tr
+	}
+
+	return tl, tr, bl
+}
+
+func distance(a, b *finderCandidate) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+// estimateDimensions returns the likely numbers of modules per side of the
+// symbol, a valid symbol size (17 + 4*version) first.
+func estimateDimensions(tl, tr, bl *finderCandidate, moduleSize float64) []int {
+	// The finder pattern centers are 7 modules from the symbol edges.
+	d := int(math.Floor((distance(tl, tr)+distance(tl, bl))/(2*moduleSize)+0.5)) + 7
+
+	var dimensions []int
+	switch d % 4 {
+	case 0:
+		dimensions = []int{d + 1, d - 3}
+	case 1:
+		dimensions = []int{d, d + 4, d - 4}
+	case 2:
+		dimensions = []int{d - 1, d + 3}
+	case 3:
+		dimensions = []int{d - 2, d + 2}
+	}
+
+	var valid []int
+	for _, dimension := range dimensions {
+		if dimension >= 21 && dimension <= 177 {
+			valid = append(valid, dimension)
+		}
+	}
+
+	return valid
+}
+
+// gridTransform returns the transform from module coordinates to image
+// coordinates for a symbol of dimension modules per side.
+func gridTransform(b *binaryImage, tl, tr, bl *finderCandidate, moduleSize float64, dimension int) perspectiveTransform {
+	d := float64(dimension)
+
+	// Bottom right corner assuming no perspective distortion.
+	brX := tr.x - tl.x + bl.x
+	brY := tr.y - tl.y + bl.y
+
+	if dimension > 21 {
+		// The bottom right alignment pattern center is 3 modules closer to the
+		// top left finder pattern than the finder pattern centers are apart.
+		correction := 1 - 3/(d-7)
+		estimateX := tl.x + correction*(brX-tl.x)
+		estimateY := tl.y + correction*(brY-tl.y)
+
+		for _, allowance := range []float64{4, 8, 16} {
+			if x, y, ok := b.findAlignmentPattern(estimateX, estimateY, moduleSize, allowance); ok {
+				return quadrilateralToQuadrilateral(
+					[8]float64{3.5, 3.5, d - 3.5, 3.5, d - 6.5, d - 6.5, 3.5, d - 3.5},
+					[8]float64{tl.x, tl.y, tr.x, tr.y, x, y, bl.x, bl.y})
+			}
+		}
+	}
+
+	return quadrilateralToQuadrilateral(
+		[8]float64{3.5, 3.5, d - 3.5, 3.5, d - 3.5, d - 3.5, 3.5, d - 3.5},
+		[8]float64{tl.x, tl.y, tr.x, tr.y, brX, brY, bl.x, bl.y})
+}
+
+// findAlignmentPattern searches for an alignment pattern within allowance
+// modules of (x, y), and returns the center of the closest one found.
+func (b *binaryImage) findAlignmentPattern(x float64, y float64, moduleSize float64, allowance float64) (float64, float64, bool) {
+	radius := int(allowance * moduleSize)
+	minX, maxX := clamp(int(x)-radius, 0, b.width), clamp(int(x)+radius, 0, b.width)
+	minY, maxY := clamp(int(y)-radius, 0, b.height), clamp(int(y)+radius, 0, b.height)
+
+	bestX, bestY, bestDistance := 0.0, 0.0, math.Inf(1)
+
+	for row := minY; row < maxY; row++ {
+		// Runs of light ring, dark center, light ring.
+		var counts [3]int
+		state := -1
+
+		for col := minX; col <= maxX; col++ {
+			// The pixel past the end of the row terminates the last light run.
+			dark := col >= maxX || b.at(col, row)
+
+			switch {
+			case state == -1:
+				if !dark {
+					state = 0
+					counts = [3]int{1, 0, 0}
+				}
+			case dark == (state == 1):
+				counts[state]++
+			case state < 2:
+				state++
+				counts[state]++
+			default:
+				if isAlignmentRatio(counts, moduleSize) {
+					centerX := float64(col-counts[2]) - float64(counts[1])/2
+					if centerY, ok := b.alignmentCenterY(int(centerX), row, moduleSize); ok {
+						if dist := math.Hypot(centerX-x, centerY-y); dist < bestDistance {
+							bestX, bestY, bestDistance = centerX, centerY, dist
+						}
+					}
+				}
+
+				// The light run may start the next pattern.
+				counts = [3]int{counts[2], 1, 0}
+				state = 1
+			}
+		}
+	}
+
+	return bestX, bestY, !math.IsInf(bestDistance, 1)
+}
+
+// alignmentCenterY cross-checks an alignment pattern center vertically through
+// (x, y) and returns its y coordinate.
+func (b *binaryImage) alignmentCenterY(x int, y int, moduleSize float64) (float64, bool) {
+	limit := int(2 * moduleSize)
+
+	up := 0
+	for b.at(x, y-up-1) && up < limit {
+		up++
+	}
+
+	down := 0
+	for b.at(x, y+down+1) && down < limit {
+		down++
+	}
+
+	var counts [3]int
+	counts[1] = up + down + 1
+
+	for !b.at(x, y-up-1-counts[0]) && counts[0] < limit {
+		counts[0]++
+	}
+
+	for !b.at(x, y+down+1+counts[2]) && counts[2] < limit {
+		counts[2]++
+	}
+
+	if !isAlignmentRatio(counts, moduleSize) {
+		return 0, false
+	}
+
+	return float64(y-up) + float64(counts[1])/2, true
+}
+
+// sampleGrid samples the module centers of a symbol of dimension modules per
+// side.
+func sampleGrid(b *binaryImage, transform perspectiveTransform, dimension int) [][]bool {
+	modules := make([][]bool, dimension)
+
+	for y := range modules {
+		modules[y] = make([]bool, dimension)
+
+		for x := range modules[y] {
+			px, py := transform.transform(float64(x)+0.5, float64(y)+0.5)
+			modules[y][x] = b.at(int(math.Floor(px)), int(math.Floor(py)))
+		}
+	}
+
+	return modules
+}
+
+// perspectiveTransform is a projective mapping between two planes:
+//
+//	x' = (a11*x + a21*y + a31) / (a13*x + a23*y + a33)
+//	y' = (a12*x + a22*y + a32) / (a13*x + a23*y + a33)
+type perspectiveTransform struct {
+	a11, a12, a13 float64
+	a21, a22, a23 float64
+	a31, a32, a33 float64
+}
+
+// transform maps the point (x, y).
+func (t perspectiveTransform) transform(x float64, y float64) (float64, float64) {
+	denominator := t.a13*x + t.a23*y + t.a33
+
+	return (t.a11*x + t.a21*y + t.a31) / denominator, (t.a12*x + t.a22*y + t.a32) / denominator
+}
+
+// quadrilateralToQuadrilateral returns the transform mapping the quadrilateral
+// src onto dst. Both quadrilaterals are given as the x, y coordinates of their
+// four corners, clockwise from the top left corner.
+func quadrilateralToQuadrilateral(src [8]float64, dst [8]float64) perspectiveTransform {
+	return squareToQuadrilateral(dst).times(squareToQuadrilateral(src).adjoint())
+}
+
+// squareToQuadrilateral returns the transform mapping the unit square onto the
+// quadrilateral q.
+func squareToQuadrilateral(q [8]float64) perspectiveTransform {
+	x0, y0, x1, y1, x2, y2, x3, y3 := q[0], q[1], q[2], q[3], q[4], q[5], q[6], q[7]
+
+	dx3 := x0 - x1 + x2 - x3
+	dy3 := y0 - y1 + y2 - y3
+
+	if dx3 == 0 && dy3 == 0 {
+		// Affine transform.
+		return perspectiveTransform{
+			a11: x1 - x0, a21: x2 - x1, a31: x0,
+			a12: y1 - y0, a22: y2 - y1, a32: y0,
+			a13: 0, a23: 0, a33: 1,
+		}
+	}
+
+	dx1, dx2 := x1-x2, x3-x2
+	dy1, dy2 := y1-y2, y3-y2
+
+	denominator := dx1*dy2 - dx2*dy1
+	a13 := (dx3*dy2 - dx2*dy3) / denominator
+	a23 := (dx1*dy3 - dx3*dy1) / denominator
+
+	return perspectiveTransform{
+		a11: x1 - x0 + a13*x1, a21: x3 - x0 + a23*x3, a31: x0,
+		a12: y1 - y0 + a13*y1, a22: y3 - y0 + a23*y3, a32: y0,
+		a13: a13, a23: a23, a33: 1,
+	}
+}
+
+// adjoint returns the adjoint of t, which is the inverse transform up to a
+// scale factor.
+func (t perspectiveTransform) adjoint() perspectiveTransform {
+	return perspectiveTransform{
+		a11: t.a22*t.a33 - t.a23*t.a32,
+		a21: t.a23*t.a31 - t.a21*t.a33,
+		a31: t.a21*t.a32 - t.a22*t.a31,
+		a12: t.a13*t.a32 - t.a12*t.a33,
+		a22: t.a11*t.a33 - t.a13*t.a31,
+		a32: t.a12*t.a31 - t.a11*t.a32,
+		a13: t.a12*t.a23 - t.a13*t.a22,
+		a23: t.a13*t.a21 - t.a11*t.a23,
+		a33: t.a11*t.a22 - t.a12*t.a21,
+	}
+}
+
+// times returns the transform applying o, then t.
+func (t perspectiveTransform) times(o perspectiveTransform) perspectiveTransform {
+	return perspectiveTransform{
+		a11: t.a11*o.a11 + t.a21*o.a12 + t.a31*o.a13,
+		a21: t.a11*o.a21 + t.a21*o.a22 + t.a31*o.a23,
+		a31: t.a11*o.a31 + t.a21*o.a32 + t.a31*o.a33,
+		a12: t.a12*o.a11 + t.a22*o.a12 + t.a32*o.a13,
+		a22: t.a12*o.a21 + t.a22*o.a22 + t.a32*o.a23,
+		a32: t.a12*o.a31 + t.a22*o.a32 + t.a32*o.a33,
+		a13: t.a13*o.a11 + t.a23*o.a12 + t.a33*o.a13,
+		a23: t.a13*o.a21 + t.a23*o.a22 + t.a33*o.a23,
+		a33: t.a13*o.a31 + t.a23*o.a32 + t.a33*o.a33,
+	}
+}
@@ -0,0 +1,179 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// testMaskImage returns a colourful noisy picture encoded as a PNG.
+func testMaskImage() []byte {
+	r := rand.New(rand.NewSource(1))
+
+	m := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			m.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(r.Intn(256)), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, m)
+
+	return buf.Bytes()
+}
+
+// warp returns img seen through the perspective transform t, which maps the
+// output image coordinates to img coordinates.
+func warp(img image.Image, size int, t perspectiveTransform) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(out, out.Rect, image.White, image.Point{}, draw.Src)
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			sx, sy := t.transform(float64(x)+0.5, float64(y)+0.5)
+
+			p := image.Pt(int(sx), int(sy))
+			if p.In(img.Bounds()) {
+				out.Set(x, y, img.At(p.X, p.Y))
+			}
+		}
+	}
+
+	return out
+}
+
+func TestDecodeImage(t *testing.T) {
+	mask := testMaskImage()
+
+	tests := []struct {
+		content string
+		level   RecoveryLevel
+		mask    bool
+	}{
+		{"hello", Medium, false},
+		{"http://www.example.org", Highest, false},
+		{"hello", Low, true},
+		{"http://www.example.org/some/long/path?with=query&and=more", Highest, true},
+		{string(make([]byte, 300)), Low, true},
+		{string(make([]byte, 300)), Highest, true},
+	}
+
+	for _, test := range tests {
+		q, err := NewHalftoneCode(test.content, test.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.mask {
+			q.AddOption(Option{MaskImageFile: bytes.NewReader(mask)})
+		}
+
+		img, err := q.CodeImage(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := DecodeImage(img)
+		if err != nil {
+			t.Errorf("version %d got %s, expected success", q.VersionNumber, err.Error())
+		} else if d.Content != test.content {
+			t.Errorf("version %d decoded %q, expected %q", q.VersionNumber, d.Content, test.content)
+		}
+	}
+}
+
+func TestDecodeImageTransformed(t *testing.T) {
+	tests := []struct {
+		name string
+		mask bool
+		size int
+		dst  [8]float64
+	}{
+		// Corners of the code in the output image, clockwise from the top left.
+		{"scaled", true, 400, [8]float64{20, 20, 380, 20, 380, 380, 20, 380}},
+		{"rotated", true, 300, [8]float64{280, 20, 280, 280, 20, 280, 20, 20}},
+		{"perspective", false, 400, [8]float64{40, 30, 360, 10, 390, 390, 20, 350}},
+	}
+
+	for _, test := range tests {
+		q, err := NewHalftoneCode("http://www.example.org", Highest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.mask {
+			q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+		}
+
+		img, err := q.CodeImage(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := float64(img.Bounds().Dx())
+		transform := quadrilateralToQuadrilateral(test.dst, [8]float64{0, 0, s, 0, s, s, 0, s})
+
+		d, err := DecodeImage(warp(img, test.size, transform))
+		if err != nil {
+			t.Errorf("%s got %s, expected success", test.name, err.Error())
+		} else if d.Content != q.Content {
+			t.Errorf("%s decoded %q, expected %q", test.name, d.Content, q.Content)
+		}
+	}
+}
+
+func TestDecodeImageGif(t *testing.T) {
+	mask := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 100, 100), palette.Plan9)
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				frame.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), uint8(i * 255), 255})
+			}
+		}
+
+		mask.Image = append(mask.Image, frame)
+		mask.Delay = append(mask.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, mask); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AddOption(Option{MaskImageFile: &buf})
+
+	g, err := q.CodeGif(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, frame := range g.Image {
+		d, err := DecodeImage(frame)
+		if err != nil {
+			t.Errorf("frame %d got %s, expected success", i, err.Error())
+		} else if d.Content != q.Content {
+			t.Errorf("frame %d decoded %q, expected %q", i, d.Content, q.Content)
+		}
+	}
+}
+
+func TestDecodeImageNoCode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Rect, image.White, image.Point{}, draw.Src)
+
+	if _, err := DecodeImage(img); err == nil {
+		t.Error("blank image decoded, expected error")
+	}
+}
@@ -0,0 +1,199 @@
+package qart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/disintegration/imaging"
+	"github.com/xrlin/qart/bitset"
+	"golang.org/x/image/vector"
+)
+
+// maxLogoSize is the largest logo allowed, as a fraction of the symbol width.
+const maxLogoSize = 0.5
+
+// ErrInvalidLogoSize is returned when EncodeOption reserves a logo larger than
+// half of the symbol.
+var ErrInvalidLogoSize = errors.New("logo size must be between 0 and 0.5")
+
+// LogoError is returned when no version and error recovery level allowed by
+// EncodeOption corrects the codewords under the logo reserved.
+type LogoError struct {
+	// Logo size requested.
+	LogoSize float64
+
+	// Largest version tried.
+	MaxVersion int
+}
+
+func (e *LogoError) Error() string {
+	return fmt.Sprintf("logo of size %g too large for versions up to %d", e.LogoSize, e.MaxVersion)
+}
+
+// logoArea returns the modules of the symbol of version v, without quiet zone,
+// covered by a logo of size times the symbol width. The area is centered and at
+// least a module wide.
+func logoArea(v qrCodeVersion, size float64) image.Rectangle {
+	symbolSize := v.symbolSize()
+
+	n := int(math.Ceil(size * float64(symbolSize)))
+	if (symbolSize-n)%2 != 0 {
+		n++
+	}
+
+	offset := (symbolSize - n) / 2
+	return image.Rect(offset, offset, offset+n, offset+n)
+}
+
+// logoFits reports whether the logo area of version v leaves the finder
+// patterns, their separators and the format information clear, and whether
+// every block corrects the codewords with a module under the logo within
+// defaultErrorBudget of its capacity. The alignment patterns are drawn over
+// the logo.
+func logoFits(v qrCodeVersion, area image.Rectangle) bool {
+	// The finder pattern, separator and format information in the top left
+	// corner are 9 modules wide, the others are symmetric.
+	if area.Min.X < finderPatternSize+2 {
+		return false
+	}
+
+	blockModules := versionBlockModules(v)
+	blockID := 0
+
+	for _, b := range v.block {
+		for j := 0; j < b.numBlocks; j++ {
+			covered := make(map[int]bool)
+			for k, p := range blockModules[blockID] {
+				if p.In(area) {
+					covered[k/8] = true
+				}
+			}
+
+			if len(covered) > int(defaultErrorBudget*float64(v.errorCapacity(b))) {
+				return false
+			}
+
+			blockID++
+		}
+	}
+
+	return true
+}
+
+// chooseLogoEncoding chooses the encoding as chooseEncoding, in the smallest
+// version and then the lowest error recovery level, from level, correcting the
+// logo reserved by opt.
+func chooseLogoEncoding(content string, level RecoveryLevel, opt EncodeOption,
+	sa *structuredAppend) (*dataEncoder, *bitset.Bitset, *qrCodeVersion, error) {
+	if opt.LogoSize < 0 || opt.LogoSize > maxLogoSize {
+		return nil, nil, nil, ErrInvalidLogoSize
+	}
+
+	_, maxVersion, err := opt.versionRange()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The smallest version holding the content without logo.
+	encoder, encoded, chosenVersion, err := chooseEncoding(content, level, opt, sa)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for version := chosenVersion.version; version <= maxVersion; version++ {
+		for l := level; l <= Highest; l++ {
+			versionOpt := opt
+			versionOpt.Version, versionOpt.MinVersion = version, 0
+
+			encoder, encoded, chosenVersion, err = chooseEncoding(content, l, versionOpt, sa)
+			if _, ok := err.(*CapacityError); ok {
+				continue
+			} else if err != nil {
+				return nil, nil, nil, err
+			}
+
+			if logoFits(*chosenVersion, logoArea(*chosenVersion, opt.LogoSize)) {
+				return encoder, encoded, chosenVersion, nil
+			}
+		}
+	}
+
+	return nil, nil, nil, &LogoError{LogoSize: opt.LogoSize, MaxVersion: maxVersion}
+}
+
+// getLogoImage returns the logo image set by the options, or nil.
+func (q *HalftoneQRCode) getLogoImage() (image.Image, error) {
+	var f io.Reader
+
+	if q.option.LogoImageFile != nil {
+		b, _ := ioutil.ReadAll(q.option.LogoImageFile)
+		q.AddOption(Option{LogoImageFile: bytes.NewBuffer(b)})
+		f = bytes.NewBuffer(b)
+	} else if q.option.LogoImagePath != "" {
+		file, err := os.Open(q.option.LogoImagePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		f = file
+	} else {
+		return nil, nil
+	}
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// drawLogo draws the logo over the area reserved for it, in the rectangle
+// codeRect of img holding the modules, and the alignment patterns under it
+// over the logo.
+func (q *HalftoneQRCode) drawLogo(img draw.Image, codeRect image.Rectangle, moduleWidth int) error {
+	logo, err := q.getLogoImage()
+	if err != nil || logo == nil {
+		return err
+	}
+
+	if q.logoArea.Empty() {
+		return errors.New("logo requires the logo size to be set by EncodeOption")
+	}
+
+	quietZone := q.symbol.quietZoneSize
+	area := image.Rect(q.logoArea.Min.X*moduleWidth, q.logoArea.Min.Y*moduleWidth, q.logoArea.Max.X*moduleWidth,
+		q.logoArea.Max.Y*moduleWidth).Add(codeRect.Min).Add(image.Pt(quietZone*moduleWidth, quietZone*moduleWidth))
+
+	if q.option.LogoPlateColor != nil {
+		z := vector.NewRasterizer(area.Dx(), area.Dy())
+		drawModuleShape(img, z, area, q.option.LogoPlateColor, roundedShape{radius: float32(q.option.LogoPlateRadius)},
+			Neighbours{})
+	}
+
+	inner := area.Inset(q.option.LogoPadding)
+	if inner.Empty() {
+		return errors.New("logo padding larger than the logo")
+	}
+
+	// Fit the logo in the area, keeping its aspect ratio.
+	logo = imaging.Fit(logo, inner.Dx(), inner.Dy(), imaging.Lanczos)
+	offset := image.Pt((inner.Dx()-logo.Bounds().Dx())/2, (inner.Dy()-logo.Bounds().Dy())/2)
+
+	draw.Draw(img, logo.Bounds().Sub(logo.Bounds().Min).Add(inner.Min).Add(offset), logo, logo.Bounds().Min,
+		draw.Over)
+
+	// From version 7 the logo covers the central alignment pattern, drawn
+	// over it for the scanners to find.
+	for _, r := range q.symbol.alignmentPatterns {
+		if r.Sub(image.Pt(quietZone, quietZone)).Overlaps(q.logoArea) {
+			q.drawAlignmentPattern(img, codeRect, moduleWidth, r)
+		}
+	}
+
+	return nil
+}
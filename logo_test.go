@@ -0,0 +1,194 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+func testLogoImage() []byte {
+	m := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(m, m.Rect, image.NewUniform(color.RGBA{0xff, 0, 0, 0xff}), image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, m)
+
+	return buf.Bytes()
+}
+
+func TestLogoEncoding(t *testing.T) {
+	tests := []struct {
+		level    RecoveryLevel
+		opt      EncodeOption
+		expected error
+	}{
+		{Low, EncodeOption{LogoSize: 0.2}, nil},
+		{Low, EncodeOption{LogoSize: 0.3}, nil},
+		{Highest, EncodeOption{LogoSize: 0.3}, nil},
+		{Low, EncodeOption{LogoSize: 0.3, Version: 22}, nil},
+		{Low, EncodeOption{LogoSize: 0.3, Version: 2}, &LogoError{}},
+		{Low, EncodeOption{LogoSize: 0.6}, ErrInvalidLogoSize},
+		{Low, EncodeOption{LogoSize: -0.1}, ErrInvalidLogoSize},
+	}
+
+	for i, test := range tests {
+		q, err := NewHalftoneCodeWithOption("http://www.example.org", test.level, test.opt)
+
+		switch expected := test.expected.(type) {
+		case nil:
+			if err != nil {
+				t.Errorf("test %d: got %s, expected success", i, err.Error())
+				continue
+			}
+		case *LogoError:
+			if _, ok := err.(*LogoError); !ok {
+				t.Errorf("test %d: got %v, expected a logo error", i, err)
+			}
+			continue
+		default:
+			if err != expected {
+				t.Errorf("test %d: got %v, expected %s", i, err, expected.Error())
+			}
+			continue
+		}
+
+		if q.Level < test.level {
+			t.Errorf("test %d: got level %d, expected at least %d", i, q.Level, test.level)
+		}
+
+		if !logoFits(q.version, q.logoArea) {
+			t.Errorf("test %d: got logo area %v not corrected by version %d level %d", i, q.logoArea,
+				q.VersionNumber, q.Level)
+		}
+
+		// The logo covers LogoSize of the symbol, rounded up.
+		if n := float64(q.logoArea.Dx()) / float64(q.symbol.symbolSize); n < test.opt.LogoSize {
+			t.Errorf("test %d: got logo of %g of the symbol, expected %g", i, n, test.opt.LogoSize)
+		}
+	}
+}
+
+func TestLogoEncodingBumps(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withLogo, err := NewHalftoneCodeWithOption("http://www.example.org", Low, EncodeOption{LogoSize: 0.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withLogo.VersionNumber <= q.VersionNumber && withLogo.Level <= q.Level {
+		t.Errorf("got version %d level %d, expected more than version %d level %d", withLogo.VersionNumber,
+			withLogo.Level, q.VersionNumber, q.Level)
+	}
+}
+
+func TestLogoDrawing(t *testing.T) {
+	const moduleWidth = 9
+
+	q, err := NewHalftoneCodeWithOption("http://www.example.org", Medium, EncodeOption{LogoSize: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plate := color.RGBA{0, 0, 0xff, 0xff}
+	q.AddOption(Option{LogoImageFile: bytes.NewReader(testLogoImage()), LogoPadding: moduleWidth,
+		LogoPlateColor: plate, LogoPlateRadius: 0.2})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quietZone := image.Pt(q.symbol.quietZoneSize, q.symbol.quietZoneSize)
+	area := image.Rectangle{q.logoArea.Min.Add(quietZone).Mul(moduleWidth),
+		q.logoArea.Max.Add(quietZone).Mul(moduleWidth)}
+	center := area.Min.Add(area.Max).Div(2)
+
+	at := func(p image.Point) color.RGBA {
+		return color.RGBAModel.Convert(img.At(p.X, p.Y)).(color.RGBA)
+	}
+
+	for _, pixel := range []struct {
+		name     string
+		p        image.Point
+		expected color.RGBA
+	}{
+		{"center", center, color.RGBA{0xff, 0, 0, 0xff}},
+		// The logo is twice as wide as high.
+		{"plate", image.Pt(center.X, area.Min.Y+moduleWidth+1), plate},
+		{"padding", image.Pt(area.Min.X+moduleWidth/2, center.Y), plate},
+	} {
+		if c := at(pixel.p); c != pixel.expected {
+			t.Errorf("got %s %v, expected %v", pixel.name, c, pixel.expected)
+		}
+	}
+
+	// Rounded corners.
+	if c := at(area.Min); c == plate {
+		t.Errorf("got plate corner %v, expected rounded", c)
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected the code to scan", err.Error())
+	}
+
+	// No area reserved.
+	q, err = NewHalftoneCode("http://www.example.org", Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{LogoImageFile: bytes.NewReader(testLogoImage())})
+	if _, err := q.CodeImage(3); err == nil {
+		t.Error("got success, expected an error without logo size")
+	}
+}
+
+func TestLogoAlignmentPattern(t *testing.T) {
+	const moduleWidth = 9
+
+	q, err := NewHalftoneCodeWithOption("http://www.example.org", Medium, EncodeOption{Version: 7, LogoSize: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plate := color.RGBA{0, 0, 0xff, 0xff}
+	q.AddOption(Option{LogoImageFile: bytes.NewReader(testLogoImage()), LogoPlateColor: plate})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(x, y int) color.RGBA {
+		quietZone := q.symbol.quietZoneSize
+		return color.RGBAModel.Convert(img.At((x+quietZone)*moduleWidth+moduleWidth/2,
+			(y+quietZone)*moduleWidth+moduleWidth/2)).(color.RGBA)
+	}
+
+	// The logo covers modules 18 to 26, the central alignment pattern 20 to
+	// 24, drawn over the logo and its plate.
+	for _, module := range []struct {
+		x, y     int
+		expected color.RGBA
+	}{
+		{22, 22, color.RGBA{0, 0, 0, 0xff}},
+		{21, 22, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{20, 22, color.RGBA{0, 0, 0, 0xff}},
+		{19, 22, plate},
+	} {
+		if c := at(module.x, module.y); c != module.expected {
+			t.Errorf("got module %d,%d %v, expected %v", module.x, module.y, c, module.expected)
+		}
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected the code to scan", err.Error())
+	}
+}
@@ -0,0 +1,298 @@
+package qart
+
+import (
+	"image"
+	"log"
+	"math/bits"
+	"sort"
+
+	"github.com/xrlin/qart/bitset"
+	"github.com/xrlin/qart/reedsolomon"
+)
+
+// followImage rebuilds the symbol so that its modules follow img, the mask
+// image, see Option.ControlModules.
+func (q *HalftoneQRCode) followImage(img image.Image) error {
+	size := q.symbol.size
+
+	// The image reduced to a pixel per module.
+	small, err := q.getMaskAreaImage(img, image.Rect(0, 0, size, size))
+	if err != nil {
+		return err
+	}
+
+	threshold := q.luminanceThreshold()
+
+	target := make([][]bool, size)
+	weight := make([][]float64, size)
+	for y := range target {
+		target[y] = make([]bool, size)
+		weight[y] = make([]float64, size)
+
+		for x := range target[y] {
+			c := small.At(x, y)
+			target[y][x] = q.isForeground(c)
+
+			// The further from the threshold, the more a module stands out.
+			weight[y][x] = float64(abs(luminance(c)-threshold) + 1)
+		}
+	}
+
+	q.controlModules(target, weight)
+
+	return nil
+}
+
+// controlModules rebuilds the symbol with its padding chosen so that the
+// modules follow target as closely as possible.
+//
+// target[y][x] is the value wanted for the module at (x, y) of the bitmap, and
+// weight[y][x] how much it matters. The data bits after the content and its
+// terminator are ignored by decoders, so may take any value. The error
+// correction codewords are linear in the data bits over GF(2), so every such
+// free bit lets one more module of its block be chosen. The modules are
+// chosen in order of decreasing weight, skipping those already determined by
+// the modules chosen before them.
+func (q *HalftoneQRCode) controlModules(target [][]bool, weight [][]float64) {
+	quietZone := q.symbol.quietZoneSize
+	modules := q.blockModules()
+
+	data := q.data.Bits()
+
+	start := 0
+	blockID := 0
+
+	for _, b := range q.version.block {
+		for j := 0; j < b.numBlocks; j++ {
+			end := start + b.numDataCodewords*8
+
+			// Value wanted for every bit of the block, and its weight.
+			wanted := make([]bool, b.numCodewords*8)
+			weights := make([]float64, b.numCodewords*8)
+
+			for k, p := range modules[blockID] {
+				bx, by := p.X+quietZone, p.Y+quietZone
+
+				// The data mask is applied to the bits placed.
+				wanted[k] = target[by][bx] != dataMask(q.mask, p.X, p.Y)
+				weights[k] = weight[by][bx]
+			}
+
+			firstFree := q.numContentBits - start
+			if firstFree < 0 {
+				firstFree = 0
+			}
+
+			chooseFreeBits(data[start:end], firstFree, b.numCodewords-b.numDataCodewords, wanted, weights)
+
+			start = end
+			blockID++
+		}
+	}
+
+	q.data = bitset.New(data...)
+
+	s, err := buildHalftoneRegularSymbol(q.version, q.mask, q.encodeBlocks())
+	if err != nil {
+		log.Panic(err.Error())
+	}
+
+	q.symbol = s.withQuietZone(q.quietZone())
+}
+
+// blockModules returns the coordinates, in the symbol without quiet zone, of
+// the module of every bit of every block: modules[i][k] is the module of bit k
+// of block i, data codewords first.
+func (q *HalftoneQRCode) blockModules() [][]image.Point {
+	return versionBlockModules(q.version)
+}
+
+// versionBlockModules returns the modules of every bit of every block of
+// version v, see blockModules. They do not depend on the mask.
+func versionBlockModules(v qrCodeVersion) [][]image.Point {
+	blocks := codewordBlocks(v)
+
+	numCodewords := 0
+	for _, b := range blocks {
+		numCodewords += len(b)
+	}
+
+	// Coordinates of the module of every codeword bit, in placement order.
+	placed := make([]image.Point, 0, numCodewords*8)
+
+	m := newHalftoneRegularSymbol(v, 0)
+	m.walkDataModules(numCodewords*8, func(i int, x int, y int) {
+		placed = append(placed, image.Pt(x, y))
+	})
+
+	modules := make([][]image.Point, len(blocks))
+	for i, b := range blocks {
+		for _, index := range b {
+			modules[i] = append(modules[i], placed[index*8:index*8+8]...)
+		}
+	}
+
+	return modules
+}
+
+// chooseFreeBits sets the bits of the data of a block from firstFree onwards
+// so that the block, with its numECBytes error correction bytes, matches
+// wanted at as many of the bits with the highest weights as possible.
+func chooseFreeBits(data []bool, firstFree int, numECBytes int, wanted []bool, weights []float64) {
+	numVars := len(data) - firstFree
+	if numVars <= 0 {
+		return
+	}
+
+	for i := firstFree; i < len(data); i++ {
+		data[i] = false
+	}
+
+	// The block is the block with no free bits set, plus a linear combination
+	// of the blocks with a single free bit set.
+	constant := reedsolomon.Encode(bitset.New(data...), numECBytes).Bits()
+
+	numWords := (numVars + 63) / 64
+	coefficients := make([][]uint64, len(constant))
+	for i := range coefficients {
+		coefficients[i] = make([]uint64, numWords)
+	}
+
+	for v := 0; v < numVars; v++ {
+		coefficients[firstFree+v][v/64] |= 1 << uint(v%64)
+	}
+
+	for i := firstFree / 8; i < len(data)/8; i++ {
+		// Error correction bytes of a block with data byte i set to 1.
+		unit := make([]bool, len(data))
+		unit[i*8+7] = true
+
+		ec := reedsolomon.Encode(bitset.New(unit...), numECBytes)
+
+		column := make([]byte, numECBytes)
+		for e := range column {
+			column[e] = ec.ByteAt(len(data) + e*8)
+		}
+
+		// Bit 7 has the value 1 = a^0 in GF(2^8), bit 6 the value a^1...
+		for bit := 7; bit >= 0; bit-- {
+			if v := i*8 + bit - firstFree; v >= 0 {
+				for e, c := range column {
+					for k := 0; k < 8; k++ {
+						if c&(0x80>>uint(k)) != 0 {
+							coefficients[len(data)+e*8+k][v/64] |= 1 << uint(v%64)
+						}
+					}
+				}
+			}
+
+			for e := range column {
+				column[e] = gfMultiplyByAlpha(column[e])
+			}
+		}
+	}
+
+	order := make([]int, len(wanted))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return weights[order[i]] > weights[order[j]]
+	})
+
+	system := newGF2System(numVars)
+	for _, i := range order {
+		system.add(coefficients[i], constant[i] != wanted[i])
+
+		if system.full() {
+			break
+		}
+	}
+
+	for v, value := range system.solve() {
+		data[firstFree+v] = value
+	}
+}
+
+// gfMultiplyByAlpha returns v*a in GF(2^8) with the QR Code polynomial
+// x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiplyByAlpha(v byte) byte {
+	if v&0x80 != 0 {
+		return v<<1 ^ 0x1d
+	}
+
+	return v << 1
+}
+
+// gf2System is a system of linear equations over GF(2), kept in row echelon
+// form as the equations are added.
+type gf2System struct {
+	numVars   int
+	equations []gf2Equation
+}
+
+// gf2Equation is the equation coefficients . x = value, with the coefficients
+// packed 64 per word. The coefficient of the pivot variable is the first
+// nonzero one, and zero in every equation added later.
+type gf2Equation struct {
+	coefficients []uint64
+	value        bool
+	pivot        int
+}
+
+func newGF2System(numVars int) *gf2System {
+	return &gf2System{numVars: numVars}
+}
+
+// add adds the equation coefficients . x = value to the system, unless it is
+// implied by or contradicts the equations already added. Reports whether the
+// equation was added.
+func (s *gf2System) add(coefficients []uint64, value bool) bool {
+	c := append([]uint64(nil), coefficients...)
+
+	for _, e := range s.equations {
+		if c[e.pivot/64]&(1<<uint(e.pivot%64)) != 0 {
+			for i := range c {
+				c[i] ^= e.coefficients[i]
+			}
+			value = value != e.value
+		}
+	}
+
+	for i, w := range c {
+		if w != 0 {
+			s.equations = append(s.equations, gf2Equation{c, value, i*64 + bits.TrailingZeros64(w)})
+			return true
+		}
+	}
+
+	return false
+}
+
+// full reports whether every variable is determined by the equations.
+func (s *gf2System) full() bool {
+	return len(s.equations) == s.numVars
+}
+
+// solve returns a solution of the system, with the variables not determined
+// by the equations set to false.
+func (s *gf2System) solve() []bool {
+	x := make([]bool, s.numVars)
+
+	// The equations only involve the pivots of the equations added after them.
+	for i := len(s.equations) - 1; i >= 0; i-- {
+		e := s.equations[i]
+
+		value := e.value
+		for v := e.pivot + 1; v < s.numVars; v++ {
+			if x[v] && e.coefficients[v/64]&(1<<uint(v%64)) != 0 {
+				value = !value
+			}
+		}
+
+		x[e.pivot] = value
+	}
+
+	return x
+}
@@ -0,0 +1,136 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestGF2System(t *testing.T) {
+	s := newGF2System(3)
+
+	// x0 + x1 = 1, x1 + x2 = 1, x0 + x2 = 1 (contradicts), x2 = 1.
+	tests := []struct {
+		coefficients uint64
+		value        bool
+		expected     bool
+	}{
+		{0x3, true, true},
+		{0x6, true, true},
+		{0x5, true, false},
+		{0x4, true, true},
+	}
+
+	for _, test := range tests {
+		if added := s.add([]uint64{test.coefficients}, test.value); added != test.expected {
+			t.Errorf("%#x = %v got added %v, expected %v", test.coefficients, test.value, added,
+				test.expected)
+		}
+	}
+
+	if !s.full() {
+		t.Error("got system not full, expected full")
+	}
+
+	if x := s.solve(); !x[0] || x[1] || !x[2] {
+		t.Errorf("got %v, expected [true false true]", x)
+	}
+}
+
+// discImage returns a PNG of a dark disc on a light background.
+func discImage() []byte {
+	m := image.NewGray(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			if (x-100)*(x-100)+(y-100)*(y-100) < 70*70 {
+				m.Set(x, y, color.Black)
+			} else {
+				m.Set(x, y, color.White)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, m)
+
+	return buf.Bytes()
+}
+
+// discAgreement returns the fraction of the data modules of q matching the
+// disc of discImage.
+func discAgreement(q *HalftoneQRCode) float64 {
+	bitmap := q.Bitmap()
+	size := len(bitmap)
+
+	numData, numAgree := 0, 0
+	for y := range bitmap {
+		for x := range bitmap[y] {
+			if !q.isDataModule(x, y) {
+				continue
+			}
+
+			// Center of the module in the 200x200 image.
+			cx, cy := (2*x+1)*100/size, (2*y+1)*100/size
+			inDisc := (cx-100)*(cx-100)+(cy-100)*(cy-100) < 70*70
+
+			numData++
+			if bitmap[y][x] == inDisc {
+				numAgree++
+			}
+		}
+	}
+
+	return float64(numAgree) / float64(numData)
+}
+
+func TestControlModules(t *testing.T) {
+	q, err := NewHalftoneCodeWithOption("http://www.example.org", Low, EncodeOption{MinVersion: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := discAgreement(q); a > 0.7 {
+		t.Errorf("got %f of the modules following the image, expected about half", a)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(discImage()), ControlModules: true})
+
+	img, err := q.CodeImage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := discAgreement(q); a < 0.8 {
+		t.Errorf("got %f of the modules following the image, expected most", a)
+	}
+
+	checkRoundTrip(t, q)
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected success", err.Error())
+	}
+}
+
+func TestControlModulesFullCode(t *testing.T) {
+	// 1-L holds 17 bytes, and no padding is left.
+	q, err := NewHalftoneCode(strings.Repeat("#", 17), Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := q.ToString()
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(discImage()), ControlModules: true})
+	if _, err := q.CodeImage(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if q.ToString() != before {
+		t.Error("modules changed, expected no padding to choose")
+	}
+
+	checkRoundTrip(t, q)
+}
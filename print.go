@@ -0,0 +1,391 @@
+package qart
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Units of the sizes of PrintOption.
+const (
+	Point      = 1.0
+	Inch       = 72 * Point
+	Millimetre = Inch / 25.4
+)
+
+// printResolution is the resolution, in pixels per inch, of the mask image
+// embedded in print output.
+const printResolution = 300
+
+// PrintOption struct contains the options of the PDF and EPS output.
+type PrintOption struct {
+	// Width of the code, the whole mask image in Embed mode, in points. The
+	// height follows. 1 inch by default, e.g. 30 * Millimetre.
+	Width float64
+
+	// CMYK draws with CMYK colours, converted from the RGB colours, instead of
+	// RGB colours.
+	CMYK bool
+}
+
+// layoutPrint lays the code out for print output, and returns the scale from
+// the pixels of the layout to points.
+func (q *HalftoneQRCode) layoutPrint(opt PrintOption) (*vectorCode, float64, error) {
+	if opt.Width == 0 {
+		opt.Width = Inch
+	} else if opt.Width < 0 {
+		return nil, 0, fmt.Errorf("invalid width %g", opt.Width)
+	}
+
+	v, err := q.layoutVector(1, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Points per pixel of the code, which is scaled into the mask rectangle in
+	// Embed mode.
+	scale := opt.Width / float64(v.width)
+	codeScale := scale
+	if v.source != nil {
+		codeScale *= float64(v.embedRect.Dx()) / float64(v.size)
+	}
+
+	if maskResolution := int(math.Ceil(codeScale / Inch * printResolution)); maskResolution > 1 {
+		v, err = q.layoutVector(1, maskResolution)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return v, scale, nil
+}
+
+// CodePDF generates the code as a single page PDF document of the physical
+// size set by opt.
+//
+// The modules are drawn as vector rectangles, the mask image as an embedded
+// image of 300 pixels per inch.
+func (q *HalftoneQRCode) CodePDF(opt PrintOption) ([]byte, error) {
+	v, scale, err := q.layoutPrint(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &pdfWriter{}
+
+	const (
+		catalog = iota + 1
+		pages
+		page
+		contents
+	)
+	w.numObjects = contents
+
+	var images []string
+	var content bytes.Buffer
+
+	// Draws img in r, as the pixel coordinates have y growing downwards.
+	drawImage := func(img image.Image, r image.Rectangle) {
+		name := fmt.Sprintf("Im%d", len(images))
+		images = append(images, fmt.Sprintf("/%s %d 0 R", name, w.writeImage(img, opt.CMYK)))
+
+		fmt.Fprintf(&content, "q %d 0 0 %d %d %d cm /%s Do Q\n", r.Dx(), -r.Dy(), r.Min.X, r.Max.Y, name)
+	}
+
+	height := scale * float64(v.height)
+	fmt.Fprintf(&content, "q %s 0 0 %s 0 %s cm\n", pdfNumber(scale), pdfNumber(-scale), pdfNumber(height))
+
+	if v.source != nil {
+		drawImage(v.source, v.source.Bounds().Sub(v.source.Bounds().Min))
+
+		fmt.Fprintf(&content, "q %s 0 0 %s %d %d cm\n", pdfNumber(float64(v.embedRect.Dx())/float64(v.size)),
+			pdfNumber(float64(v.embedRect.Dy())/float64(v.size)), v.embedRect.Min.X, v.embedRect.Min.Y)
+	}
+
+	fmt.Fprintf(&content, "%s 0 0 %d %d re f\n", pdfColor(q.option.BackgroundColor, opt.CMYK), v.size, v.size)
+
+	if v.mask != nil {
+		drawImage(v.mask, v.maskRect)
+	}
+
+	for _, rects := range []struct {
+		c     color.Color
+		rects []image.Rectangle
+	}{
+		{q.option.BackgroundColor, v.light},
+		{q.option.ForegroundColor, v.dark},
+	} {
+		if len(rects.rects) == 0 {
+			continue
+		}
+
+		content.WriteString(pdfColor(rects.c, opt.CMYK))
+		for _, r := range rects.rects {
+			fmt.Fprintf(&content, "\n%d %d %d %d re", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+		}
+		content.WriteString(" f\n")
+	}
+
+	if v.source != nil {
+		content.WriteString("Q\n")
+	}
+	content.WriteString("Q\n")
+
+	w.writeObject(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages), nil)
+	w.writeObject(pages, fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", page), nil)
+	resources := "<< >>"
+	if len(images) > 0 {
+		resources = fmt.Sprintf("<< /XObject << %s >> >>", strings.Join(images, " "))
+	}
+
+	w.writeObject(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %s "+
+		"/Contents %d 0 R >>", pages, pdfNumber(scale*float64(v.width)), pdfNumber(height), resources, contents), nil)
+	w.writeObject(contents, "<< /Filter /FlateDecode", deflate(content.Bytes()))
+
+	return w.finish(catalog), nil
+}
+
+// pdfWriter writes the objects of a PDF document.
+type pdfWriter struct {
+	buf bytes.Buffer
+
+	// Offset in buf of every object written, by object number - 1.
+	offsets []int
+
+	numObjects int
+}
+
+// writeImage writes img as an image object, with a soft mask if img is not
+// opaque, and returns its object number.
+func (w *pdfWriter) writeImage(img image.Image, cmyk bool) int {
+	pixels, alpha := printPixels(img, cmyk)
+	bounds := img.Bounds()
+
+	colorSpace := "/DeviceRGB"
+	if cmyk {
+		colorSpace = "/DeviceCMYK"
+	}
+
+	w.numObjects++
+	n := w.numObjects
+
+	softMask := ""
+	if alpha != nil {
+		w.numObjects++
+		softMask = fmt.Sprintf(" /SMask %d 0 R", w.numObjects)
+
+		w.writeObject(w.numObjects, fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d "+
+			"/ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode", bounds.Dx(), bounds.Dy()),
+			deflate(alpha))
+	}
+
+	w.writeObject(n, fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s "+
+		"/BitsPerComponent 8%s /Filter /FlateDecode", bounds.Dx(), bounds.Dy(), colorSpace, softMask),
+		deflate(pixels))
+
+	return n
+}
+
+// writeObject writes object n. If stream is not nil, dict is left open for
+// the stream length to be added.
+func (w *pdfWriter) writeObject(n int, dict string, stream []byte) {
+	if w.buf.Len() == 0 {
+		// The binary comment marks the file as binary.
+		w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	}
+
+	for len(w.offsets) < n {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[n-1] = w.buf.Len()
+
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s", n, dict)
+	if stream != nil {
+		fmt.Fprintf(&w.buf, " /Length %d >>\nstream\n", len(stream))
+		w.buf.Write(stream)
+		w.buf.WriteString("\nendstream")
+	}
+	w.buf.WriteString("\nendobj\n")
+}
+
+// finish writes the cross-reference table and trailer, and returns the
+// document.
+func (w *pdfWriter) finish(root int) []byte {
+	xref := w.buf.Len()
+
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, root, xref)
+
+	return w.buf.Bytes()
+}
+
+// CodeEPS generates the code as an Encapsulated PostScript (level 2) image of
+// the physical size set by opt.
+//
+// The modules are drawn as vector rectangles, the mask image as an embedded
+// image of 300 pixels per inch. PostScript has no transparency, the mask image
+// is drawn opaque.
+func (q *HalftoneQRCode) CodeEPS(opt PrintOption) ([]byte, error) {
+	v, scale, err := q.layoutPrint(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	width, height := scale*float64(v.width), scale*float64(v.height)
+
+	fmt.Fprintf(&buf, "%%!PS-Adobe-3.0 EPSF-3.0\n")
+	fmt.Fprintf(&buf, "%%%%BoundingBox: 0 0 %d %d\n", int(math.Ceil(width)), int(math.Ceil(height)))
+	fmt.Fprintf(&buf, "%%%%HiResBoundingBox: 0 0 %s %s\n", pdfNumber(width), pdfNumber(height))
+	fmt.Fprintf(&buf, "%%%%LanguageLevel: 2\n%%%%Pages: 1\n%%%%EndComments\n")
+
+	// The pixel coordinates have y growing downwards.
+	fmt.Fprintf(&buf, "gsave\n0 %s translate %s %s scale\n", pdfNumber(height), pdfNumber(scale),
+		pdfNumber(-scale))
+
+	if v.source != nil {
+		writeEPSImage(&buf, v.source, v.source.Bounds().Sub(v.source.Bounds().Min), opt.CMYK)
+
+		fmt.Fprintf(&buf, "gsave\n%d %d translate %s %s scale\n", v.embedRect.Min.X, v.embedRect.Min.Y,
+			pdfNumber(float64(v.embedRect.Dx())/float64(v.size)), pdfNumber(float64(v.embedRect.Dy())/float64(v.size)))
+	}
+
+	fmt.Fprintf(&buf, "%s 0 0 %d %d rectfill\n", epsColor(q.option.BackgroundColor, opt.CMYK), v.size, v.size)
+
+	if v.mask != nil {
+		writeEPSImage(&buf, v.mask, v.maskRect, opt.CMYK)
+	}
+
+	for _, rects := range []struct {
+		c     color.Color
+		rects []image.Rectangle
+	}{
+		{q.option.BackgroundColor, v.light},
+		{q.option.ForegroundColor, v.dark},
+	} {
+		if len(rects.rects) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s [", epsColor(rects.c, opt.CMYK))
+		for _, r := range rects.rects {
+			fmt.Fprintf(&buf, "\n%d %d %d %d", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+		}
+		buf.WriteString("\n] rectfill\n")
+	}
+
+	if v.source != nil {
+		buf.WriteString("grestore\n")
+	}
+	buf.WriteString("grestore\nshowpage\n%%EOF\n")
+
+	return buf.Bytes(), nil
+}
+
+// writeEPSImage writes the PostScript drawing img in r.
+func writeEPSImage(buf *bytes.Buffer, img image.Image, r image.Rectangle, cmyk bool) {
+	pixels, _ := printPixels(img, cmyk)
+	bounds := img.Bounds()
+
+	colorSpace, decode := "/DeviceRGB", "0 1 0 1 0 1"
+	if cmyk {
+		colorSpace, decode = "/DeviceCMYK", "0 1 0 1 0 1 0 1"
+	}
+
+	fmt.Fprintf(buf, "gsave\n%d %d translate %d %d scale\n%s setcolorspace\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy(),
+		colorSpace)
+	fmt.Fprintf(buf, "<< /ImageType 1 /Width %d /Height %d /BitsPerComponent 8 /Decode [%s] "+
+		"/ImageMatrix [%d 0 0 %d 0 0] /DataSource currentfile /ASCIIHexDecode filter >> image\n",
+		bounds.Dx(), bounds.Dy(), decode, bounds.Dx(), bounds.Dy())
+
+	const lineLength = 64
+	for i := 0; i < len(pixels); i += lineLength {
+		end := i + lineLength
+		if end > len(pixels) {
+			end = len(pixels)
+		}
+
+		buf.WriteString(hex.EncodeToString(pixels[i:end]))
+		buf.WriteString("\n")
+	}
+	buf.WriteString(">\ngrestore\n")
+}
+
+// printPixels returns the RGB or CMYK samples of img, row by row, and its
+// alpha samples, or nil if img is opaque.
+func printPixels(img image.Image, cmyk bool) (pixels []byte, alpha []byte) {
+	bounds := img.Bounds()
+	opaque := true
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+
+			if cmyk {
+				k := color.CMYKModel.Convert(color.RGBA{c.R, c.G, c.B, 0xff}).(color.CMYK)
+				pixels = append(pixels, k.C, k.M, k.Y, k.K)
+			} else {
+				pixels = append(pixels, c.R, c.G, c.B)
+			}
+
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 0xff
+		}
+	}
+
+	if opaque {
+		alpha = nil
+	}
+
+	return pixels, alpha
+}
+
+// pdfColor returns the PDF operator setting the fill colour to c.
+func pdfColor(c color.Color, cmyk bool) string {
+	return printColor(c, cmyk, "rg", "k")
+}
+
+// epsColor returns the PostScript operator setting the colour to c.
+func epsColor(c color.Color, cmyk bool) string {
+	return printColor(c, cmyk, "setrgbcolor", "setcmykcolor")
+}
+
+func printColor(c color.Color, cmyk bool, rgbOperator string, cmykOperator string) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+
+	if cmyk {
+		k := color.CMYKModel.Convert(color.RGBA{n.R, n.G, n.B, 0xff}).(color.CMYK)
+		return fmt.Sprintf("%s %s %s %s %s", pdfNumber(float64(k.C)/0xff), pdfNumber(float64(k.M)/0xff),
+			pdfNumber(float64(k.Y)/0xff), pdfNumber(float64(k.K)/0xff), cmykOperator)
+	}
+
+	return fmt.Sprintf("%s %s %s %s", pdfNumber(float64(n.R)/0xff), pdfNumber(float64(n.G)/0xff),
+		pdfNumber(float64(n.B)/0xff), rgbOperator)
+}
+
+// pdfNumber formats f as a PDF or PostScript real number.
+func pdfNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// deflate returns data compressed with zlib, as for the FlateDecode filter.
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+
+	z := zlib.NewWriter(&buf)
+	z.Write(data)
+	z.Close()
+
+	return buf.Bytes()
+}
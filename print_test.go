@@ -0,0 +1,169 @@
+package qart
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pdfObjects returns the objects of a PDF document by number, checking its
+// cross-reference table.
+func pdfObjects(t *testing.T, data []byte) map[int]string {
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatal("got no PDF header, expected one")
+	}
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if match == nil {
+		t.Fatal("got no startxref, expected one")
+	}
+
+	xref, _ := strconv.Atoi(string(match[1]))
+	if xref >= len(data) || !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("got startxref %d, expected the offset of the xref table", xref)
+	}
+
+	objects := make(map[int]string)
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+
+		prefix := fmt.Sprintf("%d 0 obj\n", i+1)
+		if !bytes.HasPrefix(data[offset:], []byte(prefix)) {
+			t.Fatalf("got offset %d for object %d, expected the offset of the object", offset, i+1)
+		}
+
+		end := bytes.Index(data[offset:], []byte("\nendobj\n"))
+		objects[i+1] = string(data[offset+len(prefix) : offset+end])
+	}
+
+	return objects
+}
+
+// pdfStream returns the decompressed stream of a PDF object.
+func pdfStream(t *testing.T, object string) string {
+	start := strings.Index(object, "stream\n")
+	end := strings.LastIndex(object, "\nendstream")
+	if start < 0 || end < 0 {
+		t.Fatal("got no stream, expected one")
+	}
+
+	z, err := zlib.NewReader(strings.NewReader(object[start+len("stream\n") : end]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestCodePDF(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := q.CodePDF(PrintOption{Width: 30 * Millimetre})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := pdfObjects(t, data)
+	if len(objects) != 4 {
+		t.Fatalf("got %d objects, expected 4", len(objects))
+	}
+
+	if !strings.Contains(objects[3], "/MediaBox [0 0 85.03937007874016 85.03937007874016]") {
+		t.Errorf("got page %q, expected a 30mm MediaBox", objects[3])
+	}
+
+	content := pdfStream(t, objects[4])
+
+	if !strings.Contains(content, "1 1 1 rg 0 0 87 87 re f") || !strings.Contains(content, "0 0 0 rg") {
+		t.Errorf("got content %q, expected RGB background and modules", content)
+	}
+
+	if n := strings.Count(content, " re"); n < 2 {
+		t.Errorf("got %d rectangles, expected the modules", n)
+	}
+}
+
+func TestCodePDFMaskCMYK(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+
+	data, err := q.CodePDF(PrintOption{Width: Inch, CMYK: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := pdfObjects(t, data)
+	if len(objects) != 5 {
+		t.Fatalf("got %d objects, expected 5", len(objects))
+	}
+
+	// The 87 pixels of the code in an inch are printed at 300dpi at least.
+	if !strings.Contains(objects[5], "/Width 348 /Height 348 /ColorSpace /DeviceCMYK") {
+		t.Errorf("got image %q, expected a 348 pixels CMYK image", objects[5][:100])
+	}
+
+	if n := len(pdfStream(t, objects[5])); n != 348*348*4 {
+		t.Errorf("got %d bytes of image, expected %d", n, 348*348*4)
+	}
+
+	content := pdfStream(t, objects[4])
+	if !strings.Contains(content, "/Im0 Do") || !strings.Contains(content, "0 0 0 1 k") ||
+		strings.Contains(content, "rg") {
+		t.Errorf("got content %q, expected the image and CMYK colours", content)
+	}
+}
+
+func TestCodeEPS(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+
+	for _, cmyk := range []bool{false, true} {
+		data, err := q.CodeEPS(PrintOption{Width: 2 * Inch, CMYK: cmyk})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		eps := string(data)
+
+		if !strings.HasPrefix(eps, "%!PS-Adobe-3.0 EPSF-3.0\n%%BoundingBox: 0 0 144 144\n") {
+			t.Errorf("got header %q, expected a 2 inch bounding box", eps[:60])
+		}
+
+		operator := "setrgbcolor"
+		if cmyk {
+			operator = "setcmykcolor"
+		}
+
+		if strings.Count(eps, operator) != 3 || strings.Count(eps, "rectfill") != 3 ||
+			strings.Count(eps, " image\n") != 1 {
+			t.Errorf("got %d %s %d rectfill, expected the background, light and dark rectangles",
+				strings.Count(eps, operator), operator, strings.Count(eps, "rectfill"))
+		}
+
+		if !strings.HasSuffix(eps, "showpage\n%%EOF\n") {
+			t.Error("got no EOF, expected one")
+		}
+	}
+}
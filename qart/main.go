@@ -12,8 +12,33 @@ import (
 
 func main() {
 	maskImage := flag.String("m", "", "mask image path")
-	outFile := flag.String("o", "", "out PNG/GIF file prefix")
-	pointWidth := flag.Int("pw", 3, "image point width (module)")
+	outFile := flag.String("o", "", "out PNG/GIF/SVG/PDF/EPS file prefix")
+	format := flag.String("format", "png", "output format: png (gif with a gif mask image), svg, pdf or eps")
+	printWidth := flag.Float64("mm", 25.4, "code width in millimetres, the whole image with -embed, for pdf and eps")
+	cmyk := flag.Bool("cmyk", false, "use CMYK colors, for pdf and eps")
+	pointWidth := flag.Int("pw", 3, "image point width (a block of a module)")
+	grid := flag.Int("grid", 3, "number of blocks (2-7) per side of a module")
+	core := flag.Int("core", 1, "number of blocks per side of the module core keeping the module color")
+	shapeName := flag.String("shape", "", "shape of the data modules: square, circle, rounded, diamond, hbar, vbar or liquid")
+	functionShapeName := flag.String("function-shape", "", "shape of the function pattern modules, see -shape")
+	eyeShapeName := flag.String("eye", "", "draw the finder and alignment patterns as a whole, in a shape as for -shape")
+	maskFill := flag.Bool("mask-fill", false, "color the dark modules like the mask image around them, darkened for contrast")
+	minContrast := flag.Float64("contrast", 4.5, "smallest contrast ratio (1-21) of the dark modules colored by -mask-fill")
+	logo := flag.String("logo", "", "logo image path, drawn at the center of the code")
+	logoSize := flag.Float64("logo-size", 0.2, "logo width (0-0.5) relative to the code, larger logos raise the version or level")
+	logoPadding := flag.Int("logo-padding", 0, "pixels between the logo and the edges of its area")
+	logoPlate := flag.Bool("logo-plate", false, "draw a rounded plate of the background color behind the logo")
+	quietZone := flag.Int("quiet-zone", 4, "width in modules of the light border around the code, 0 for none")
+	quietZoneImage := flag.Bool("quiet-zone-image", false, "show the mask image lightened in the quiet zone")
+	transparent := flag.Bool("transparent", false, "draw the light modules transparent, but the quiet zone and the finder patterns")
+	ditherName := flag.String("dither", "", "dither the mask image: floyd-steinberg, atkinson, jjn or bayer")
+	control := flag.Bool("control", false, "make the modules follow the mask image")
+	charsetName := flag.String("charset", "", "declare the charset the content is encoded in, e.g. utf-8, iso-8859-1 or shift-jis")
+	minVersion := flag.Int("minversion", 0, "smallest version (1-40) of the code, larger versions follow the image better")
+	saliency := flag.Bool("saliency", false, "only show the mask image around the modules where it matters")
+	weightImage := flag.String("weight", "", "weight image path, the lighter the more important, for -saliency")
+	ditherColor := flag.Bool("dither-color", false, "dither every color channel instead of the gray levels")
+	size := flag.Int("size", 0, "image width and height, overrides -pw")
 	textArt := flag.Bool("t", false, "print as pure text-art on stdout")
 	startX := flag.Int("startX", 0, "mask image start point")
 	startY := flag.Int("startY", 0, "mask image start point")
@@ -50,7 +75,16 @@ Tips:
 
 	var err error
 	var q *qrcode.HalftoneQRCode
-	q, err = qrcode.NewHalftoneCode(content, qrcode.Highest)
+	charset, ok := charsets[*charsetName]
+	if !ok {
+		checkError(fmt.Errorf("error: unknown charset %q", *charsetName))
+	}
+
+	encodeOption := qrcode.EncodeOption{MinVersion: *minVersion, Charset: charset}
+	if *logo != "" {
+		encodeOption.LogoSize = *logoSize
+	}
+	q, err = qrcode.NewHalftoneCodeWithOption(content, qrcode.Highest, encodeOption)
 
 	var maskRect image.Rectangle
 	if *startY >= 0 && *startX >= 0 && *width > 0 {
@@ -59,10 +93,63 @@ Tips:
 
 	checkError(err)
 
-	q.AddOption(qrcode.Option{Embed: *embed, MaskImagePath: *maskImage, MaskRectangle: maskRect})
+	dither, ok := ditherModes[*ditherName]
+	if !ok {
+		checkError(fmt.Errorf("error: unknown dither mode %q", *ditherName))
+	}
+
+	shape, ok := moduleShapes[*shapeName]
+	if !ok {
+		checkError(fmt.Errorf("error: unknown shape %q", *shapeName))
+	}
+
+	functionShape, ok := moduleShapes[*functionShapeName]
+	if !ok {
+		checkError(fmt.Errorf("error: unknown shape %q", *functionShapeName))
+	}
+
+	if *eyeShapeName != "" {
+		eyeShape, ok := moduleShapes[*eyeShapeName]
+		if !ok {
+			checkError(fmt.Errorf("error: unknown shape %q", *eyeShapeName))
+		}
+
+		q.AddOption(qrcode.Option{Eyes: &qrcode.EyeStyle{Shape: eyeShape}})
+	}
+
+	if *maskFill {
+		q.AddOption(qrcode.Option{ForegroundFill: qrcode.MaskImageFill{}, MinContrast: *minContrast})
+	}
+
+	if *quietZone < 0 {
+		checkError(fmt.Errorf("error: negative quiet zone %d", *quietZone))
+	}
+	q.AddOption(qrcode.Option{QuietZone: *quietZone, NoQuietZone: *quietZone == 0, QuietZoneImage: *quietZoneImage})
+
+	q.AddOption(qrcode.Option{LogoImagePath: *logo, LogoPadding: *logoPadding})
+	if *logoPlate {
+		q.AddOption(qrcode.Option{LogoPlateColor: q.Option().BackgroundColor, LogoPlateRadius: 0.2})
+	}
+
+	q.AddOption(qrcode.Option{Embed: *embed, MaskImagePath: *maskImage, MaskRectangle: maskRect, Size: *size,
+		Grid: *grid, CoreSize: *core, DataModuleShape: shape, FunctionModuleShape: functionShape,
+		Dither: dither, DitherColor: *ditherColor, ControlModules: *control, Saliency: *saliency,
+		WeightImagePath: *weightImage, Transparent: *transparent})
 
 	//var png []byte
-	imgBytes, err := q.ImageData(*pointWidth)
+	var imgBytes []byte
+	switch *format {
+	case "png":
+		imgBytes, err = q.ImageData(*pointWidth)
+	case "svg":
+		imgBytes, err = q.CodeSVG(*pointWidth)
+	case "pdf":
+		imgBytes, err = q.CodePDF(qrcode.PrintOption{Width: *printWidth * qrcode.Millimetre, CMYK: *cmyk})
+	case "eps":
+		imgBytes, err = q.CodeEPS(qrcode.PrintOption{Width: *printWidth * qrcode.Millimetre, CMYK: *cmyk})
+	default:
+		err = fmt.Errorf("error: unknown format %q", *format)
+	}
 	checkError(err)
 
 	if *outFile != "" {
@@ -77,6 +164,43 @@ Tips:
 
 }
 
+var charsets = map[string]qrcode.Charset{
+	"":             qrcode.NoCharset,
+	"iso-8859-1":   qrcode.CharsetISO8859_1,
+	"iso-8859-2":   qrcode.CharsetISO8859_2,
+	"iso-8859-5":   qrcode.CharsetISO8859_5,
+	"iso-8859-15":  qrcode.CharsetISO8859_15,
+	"shift-jis":    qrcode.CharsetShiftJIS,
+	"windows-1250": qrcode.CharsetWindows1250,
+	"windows-1251": qrcode.CharsetWindows1251,
+	"windows-1252": qrcode.CharsetWindows1252,
+	"utf-16be":     qrcode.CharsetUTF16BE,
+	"utf-8":        qrcode.CharsetUTF8,
+	"us-ascii":     qrcode.CharsetUSASCII,
+	"big5":         qrcode.CharsetBig5,
+	"gb18030":      qrcode.CharsetGB18030,
+	"euc-kr":       qrcode.CharsetEUCKR,
+}
+
+var ditherModes = map[string]qrcode.DitherMode{
+	"":                qrcode.NoDither,
+	"floyd-steinberg": qrcode.DitherFloydSteinberg,
+	"atkinson":        qrcode.DitherAtkinson,
+	"jjn":             qrcode.DitherJarvisJudiceNinke,
+	"bayer":           qrcode.DitherBayer,
+}
+
+var moduleShapes = map[string]qrcode.ModuleShape{
+	"":        nil,
+	"square":  qrcode.SquareShape,
+	"circle":  qrcode.CircleShape,
+	"rounded": qrcode.RoundedShape,
+	"diamond": qrcode.DiamondShape,
+	"hbar":    qrcode.HorizontalBarShape,
+	"vbar":    qrcode.VerticalBarShape,
+	"liquid":  qrcode.LiquidShape,
+}
+
 func checkError(err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -0,0 +1,39 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+)
+
+// quietZoneVeil lightens the mask image shown in the quiet zone, blending
+// 70% of BackgroundColor over it so the scanners find the edges of the symbol.
+var quietZoneVeil = image.NewUniform(color.Alpha{0xb3})
+
+// quietZone returns the width in modules of the quiet zone set by the options.
+func (q *HalftoneQRCode) quietZone() int {
+	switch {
+	case q.option.NoQuietZone:
+		return 0
+	case q.option.QuietZone > 0:
+		return q.option.QuietZone
+	}
+
+	return q.version.quietZoneSize()
+}
+
+// resizeQuietZone rebuilds the symbol if its quiet zone is not as wide as the
+// options set.
+func (q *HalftoneQRCode) resizeQuietZone() {
+	if n := q.quietZone(); q.symbol != nil && n != q.symbol.quietZoneSize {
+		q.symbol = q.symbol.withQuietZone(n)
+	}
+}
+
+// inQuietZone reports whether the module at x, y of the bitmap is part of the
+// quiet zone.
+func (q *HalftoneQRCode) inQuietZone(x, y int) bool {
+	quietZone := q.symbol.quietZoneSize
+	symbol := image.Rect(quietZone, quietZone, quietZone+q.symbol.symbolSize, quietZone+q.symbol.symbolSize)
+
+	return !image.Pt(x, y).In(symbol)
+}
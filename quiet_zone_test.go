@@ -0,0 +1,110 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestQuietZone(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opt       Option
+		remove    OptionKey
+		quietZone int
+	}{
+		{Option{}, "", 4},
+		{Option{QuietZone: 2}, "", 2},
+		{Option{QuietZone: 6}, "", 6},
+		{Option{NoQuietZone: true}, "", 0},
+		{Option{}, NoQuietZoneOpt, 6},
+		{Option{}, QuietZoneOpt, 4},
+	}
+
+	for i, test := range tests {
+		q.AddOption(test.opt)
+		if test.remove != "" {
+			q.RemoveOption(test.remove)
+		}
+
+		bitmap := q.Bitmap()
+		if len(bitmap) != 21+2*test.quietZone {
+			t.Errorf("test %d: got %d modules wide, expected a quiet zone of %d", i, len(bitmap), test.quietZone)
+			continue
+		}
+
+		// The top left finder pattern follows the quiet zone.
+		if r := q.symbol.finderPatterns[0]; r.Min != image.Pt(test.quietZone, test.quietZone) {
+			t.Errorf("test %d: got finder pattern %v, expected at the quiet zone", i, r)
+		}
+		if !bitmap[test.quietZone][test.quietZone] || (test.quietZone > 0 && bitmap[0][0]) {
+			t.Errorf("test %d: got modules not moved with the quiet zone", i)
+		}
+
+		checkRoundTrip(t, q)
+
+		img, err := q.CodeImage(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkModulePixels(t, q, img, 9, 0)
+	}
+}
+
+func TestQuietZoneImage(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	if c := at(0, 0); c != white {
+		t.Errorf("got quiet zone %v, expected the background colour", c)
+	}
+
+	q.AddOption(Option{QuietZoneImage: true})
+
+	if img, err = q.CodeImage(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mask image is dark in the top left corner, lightened to 70% of
+	// the background colour at least.
+	if c := at(0, 0); c == white || c.R < 0xb2 || c.G < 0xb2 || c.B < 0xb2 {
+		t.Errorf("got quiet zone %v, expected the mask image lightened", c)
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected the code to scan", err.Error())
+	}
+
+	// Vector output draws the background colour.
+	v, err := q.layoutVector(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range v.light {
+		if r.Min == (image.Point{}) {
+			return
+		}
+	}
+	t.Error("got no light rectangle over the top left corner, expected the background colour")
+}
@@ -214,3 +214,32 @@ func (e gfPoly) equals(other gfPoly) bool {
 
 	return true
 }
+
+// evaluate returns the value of e at x.
+func (e gfPoly) evaluate(x gfElement) gfElement {
+	var result gfElement
+
+	for i := e.numTerms() - 1; i >= 0; i-- {
+		result = gfAdd(gfMultiply(result, x), e.term[i])
+	}
+
+	return result
+}
+
+// derivative returns the formal derivative of e.
+//
+// In GF(2^8) the even powers of x vanish: the derivative of term*(x^i) is
+// term*(x^(i-1)) for odd i, and 0 for even i.
+func (e gfPoly) derivative() gfPoly {
+	if e.numTerms() < 2 {
+		return gfPoly{}
+	}
+
+	result := gfPoly{term: make([]gfElement, e.numTerms()-1)}
+
+	for i := 1; i < e.numTerms(); i += 2 {
+		result.term[i-1] = e.term[i]
+	}
+
+	return result.normalised()
+}
@@ -11,6 +11,7 @@
 package reedsolomon
 
 import (
+	"errors"
 	"log"
 
 	"github.com/xrlin/qart/bitset"
@@ -71,3 +72,169 @@ func rsGeneratorPoly(degree int) gfPoly {
 
 	return generator
 }
+
+// ErrTooManyErrors is returned by Decode when the data contains more errors
+// than the error correction bytes are able to correct.
+var ErrTooManyErrors = errors.New("reedsolomon: too many errors")
+
+// Decode corrects the errors in data, a Reed-Solomon code word with numECBytes
+// error correction bytes as produced by Encode. data is corrected in place.
+//
+// erasures lists the indexes of bytes in data known to be wrong, e.g. modules
+// covered by a logo. Each erasure consumes one error correction byte, while an
+// error at an unknown position consumes two: Decode succeeds as long as
+// 2*numErrors+len(erasures) <= numECBytes.
+//
+// The number of bytes corrected is returned. ErrTooManyErrors is returned, and
+// data left unmodified, if the errors cannot be corrected.
+func Decode(data []byte, numECBytes int, erasures []int) (int, error) {
+	n := len(data)
+
+	if n > 255 || numECBytes < 1 || numECBytes > n {
+		return 0, errors.New("reedsolomon: invalid code word length")
+	} else if len(erasures) > numECBytes {
+		return 0, ErrTooManyErrors
+	}
+
+	// Each position i in data is the coefficient of x^(n-1-i), its error locator
+	// is a^(n-1-i).
+	locator := func(i int) gfElement {
+		return gfExpTable[n-1-i]
+	}
+
+	// Erasure locator polynomial: product of (1 + X*x) for each erasure X.
+	erasureLocator := gfPoly{term: []gfElement{gfOne}}
+	seen := make(map[int]bool)
+	for _, i := range erasures {
+		if i < 0 || i >= n || seen[i] {
+			return 0, errors.New("reedsolomon: invalid erasure")
+		}
+		seen[i] = true
+
+		erasureLocator = gfPolyMultiply(erasureLocator,
+			gfPoly{term: []gfElement{gfOne, locator(i)}})
+	}
+
+	syndromes := rsSyndromes(data, numECBytes)
+	if syndromes.equals(gfPoly{}) {
+		return 0, nil
+	}
+
+	// Find the errata locator polynomial.
+	errataLocator := rsBerlekampMassey(syndromes, erasureLocator, len(erasures), numECBytes)
+
+	// Chien search: the errata positions are the inverse roots of the locator.
+	var positions []int
+	for i := 0; i < n; i++ {
+		if errataLocator.evaluate(gfInverse(locator(i))) == gfZero {
+			positions = append(positions, i)
+		}
+	}
+
+	if len(positions) != errataLocator.numTerms()-1 {
+		return 0, ErrTooManyErrors
+	}
+
+	// Forney algorithm: the magnitude of the errata at X is
+	// X * Omega(X^-1) / Lambda'(X^-1), with the errata evaluator
+	// Omega(x) = S(x) * Lambda(x) mod x^numECBytes.
+	evaluator := gfPolyMultiply(syndromes, errataLocator)
+	if evaluator.numTerms() > numECBytes {
+		evaluator.term = evaluator.term[:numECBytes]
+	}
+	evaluator = evaluator.normalised()
+
+	derivative := errataLocator.derivative()
+
+	corrected := make([]byte, n)
+	copy(corrected, data)
+
+	numCorrected := 0
+	for _, i := range positions {
+		xInverse := gfInverse(locator(i))
+
+		denominator := derivative.evaluate(xInverse)
+		if denominator == gfZero {
+			return 0, ErrTooManyErrors
+		}
+
+		magnitude := gfMultiply(locator(i),
+			gfDivide(evaluator.evaluate(xInverse), denominator))
+
+		if magnitude != gfZero {
+			corrected[i] ^= byte(magnitude)
+			numCorrected++
+		}
+	}
+
+	// The corrected code word must be valid, otherwise more errors occurred than
+	// could be located.
+	if !rsSyndromes(corrected, numECBytes).equals(gfPoly{}) {
+		return 0, ErrTooManyErrors
+	}
+
+	copy(data, corrected)
+
+	return numCorrected, nil
+}
+
+// rsSyndromes returns the syndrome polynomial of data:
+// S(x) = S_0 + S_1*x + ... with S_j = data(a^j).
+func rsSyndromes(data []byte, numECBytes int) gfPoly {
+	syndromes := gfPoly{term: make([]gfElement, numECBytes)}
+
+	for j := 0; j < numECBytes; j++ {
+		var s gfElement
+		for _, v := range data {
+			s = gfAdd(gfMultiply(s, gfExpTable[j]), gfElement(v))
+		}
+
+		syndromes.term[j] = s
+	}
+
+	return syndromes.normalised()
+}
+
+// rsBerlekampMassey returns the errata locator polynomial for syndromes.
+//
+// The iterations start from the erasure locator polynomial of the numErasures
+// erasures, so that the returned polynomial locates both the erasures and the
+// errors.
+func rsBerlekampMassey(syndromes gfPoly, erasureLocator gfPoly, numErasures int, numECBytes int) gfPoly {
+	syndrome := func(j int) gfElement {
+		if j < syndromes.numTerms() {
+			return syndromes.term[j]
+		}
+
+		return gfZero
+	}
+
+	lambda := erasureLocator
+	prev := erasureLocator
+	length := numErasures
+
+	for r := numErasures; r < numECBytes; r++ {
+		// Discrepancy between the syndrome predicted by lambda and the actual one.
+		var delta gfElement
+		for i := 0; i < lambda.numTerms() && i <= r; i++ {
+			delta = gfAdd(delta, gfMultiply(lambda.term[i], syndrome(r-i)))
+		}
+
+		prev = gfPolyMultiply(prev, newGFPolyMonomial(gfOne, 1))
+
+		if delta == gfZero {
+			continue
+		}
+
+		next := gfPolyAdd(lambda, gfPolyMultiply(prev, newGFPolyMonomial(delta, 0)))
+
+		if 2*length <= r+numErasures {
+			length = r + 1 + numErasures - length
+			prev = gfPolyMultiply(lambda, newGFPolyMonomial(gfInverse(delta), 0))
+		}
+
+		lambda = next
+	}
+
+	return lambda
+}
@@ -4,6 +4,8 @@
 package reedsolomon
 
 import (
+	"bytes"
+	"math/rand"
 	"testing"
 
 	"github.com/xrlin/qart/bitset"
@@ -87,3 +89,101 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestDecode(t *testing.T) {
+	var tests = []struct {
+		numDataBytes int
+		numECBytes   int
+		numErrors    int
+		numErasures  int
+		correctable  bool
+	}{
+		{19, 7, 0, 0, true},
+		{19, 7, 3, 0, true},
+		{19, 7, 0, 7, true},
+		{19, 7, 2, 3, true},
+		{16, 10, 5, 0, true},
+		{16, 10, 4, 2, true},
+		{16, 10, 6, 0, false},
+		{16, 10, 5, 2, false},
+		{15, 30, 15, 0, true},
+		{15, 30, 10, 10, true},
+		{15, 30, 0, 30, true},
+		{118, 68, 34, 0, true},
+		{118, 68, 20, 28, true},
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, test := range tests {
+		for iteration := 0; iteration < 20; iteration++ {
+			data := make([]byte, test.numDataBytes)
+			r.Read(data)
+
+			encoded := Encode(bitsetFromBytes(data), test.numECBytes)
+
+			codeword := make([]byte, encoded.Len()/8)
+			for i := range codeword {
+				codeword[i] = encoded.ByteAt(i * 8)
+			}
+
+			received := make([]byte, len(codeword))
+			copy(received, codeword)
+
+			// Corrupt distinct random positions, the first numErasures of which are
+			// reported as erasures.
+			positions := r.Perm(len(codeword))[:test.numErrors+test.numErasures]
+			for _, i := range positions {
+				received[i] ^= byte(1 + r.Intn(255))
+			}
+			erasures := positions[test.numErrors:]
+
+			numCorrected, err := Decode(received, test.numECBytes, erasures)
+
+			if !test.correctable {
+				// Beyond the correction capacity the decoder may either detect the
+				// failure or miscorrect to another valid code word.
+				if err == nil && bytes.Equal(received, codeword) {
+					t.Errorf("%+v: corrected beyond capacity", test)
+				}
+
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%+v: got %s, expected success", test, err.Error())
+			} else if !bytes.Equal(received, codeword) {
+				t.Errorf("%+v: got %v, expected %v", test, received, codeword)
+			} else if numCorrected != len(positions) {
+				t.Errorf("%+v: corrected %d bytes, expected %d", test, numCorrected, len(positions))
+			}
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	data := make([]byte, 26)
+
+	if _, err := Decode(data, 7, []int{26}); err == nil {
+		t.Error("erasure out of range decoded, expected error")
+	}
+
+	if _, err := Decode(data, 7, []int{1, 1}); err == nil {
+		t.Error("duplicate erasure decoded, expected error")
+	}
+
+	if _, err := Decode(data, 7, []int{0, 1, 2, 3, 4, 5, 6, 7}); err != ErrTooManyErrors {
+		t.Errorf("got %v, expected ErrTooManyErrors", err)
+	}
+
+	if _, err := Decode(make([]byte, 256), 7, nil); err == nil {
+		t.Error("256 byte code word decoded, expected error")
+	}
+}
+
+func bitsetFromBytes(data []byte) *bitset.Bitset {
+	b := bitset.New()
+	b.AppendBytes(data)
+
+	return b
+}
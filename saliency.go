@@ -0,0 +1,216 @@
+package qart
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// defaultErrorBudget is the fraction of the correction capacity of every block
+// which the modules at risk may use, unless set by Option.ErrorBudget or
+// Option.NoErrorBudget.
+const defaultErrorBudget = 0.5
+
+// getWeightImage returns the weight image set by the options, or nil.
+func (q *HalftoneQRCode) getWeightImage() (image.Image, error) {
+	var f io.Reader
+
+	if q.option.WeightImageFile != nil {
+		b, _ := ioutil.ReadAll(q.option.WeightImageFile)
+		q.AddOption(Option{WeightImageFile: bytes.NewBuffer(b)})
+		f = bytes.NewBuffer(b)
+	} else if q.option.WeightImagePath != "" {
+		file, err := os.Open(q.option.WeightImagePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		f = file
+	} else {
+		return nil, nil
+	}
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// importanceMap returns the importance, from 0 to 1, of every module of the
+// bitmap. small is the mask image reduced to a pixel per module.
+//
+// The importance is the luminance of the weight image if one is set, and the
+// gradient magnitude of the mask image otherwise, so the edges of the image
+// matter most.
+func (q *HalftoneQRCode) importanceMap(small image.Image) ([][]float64, error) {
+	size := q.symbol.size
+
+	importance := make([][]float64, size)
+	for y := range importance {
+		importance[y] = make([]float64, size)
+	}
+
+	weightImage, err := q.getWeightImage()
+	if err != nil {
+		return nil, err
+	}
+
+	if weightImage != nil {
+		weightImage = imaging.Resize(weightImage, size, size, imaging.Lanczos)
+
+		for y := range importance {
+			for x := range importance[y] {
+				importance[y][x] = float64(luminance(weightImage.At(x, y))) / 255
+			}
+		}
+
+		return importance, nil
+	}
+
+	lum := func(x int, y int) float64 {
+		return float64(luminance(small.At(clamp(x, 0, size-1), clamp(y, 0, size-1))))
+	}
+
+	// Sobel operator.
+	max := 0.0
+	for y := range importance {
+		for x := range importance[y] {
+			gx := lum(x+1, y-1) + 2*lum(x+1, y) + lum(x+1, y+1) -
+				lum(x-1, y-1) - 2*lum(x-1, y) - lum(x-1, y+1)
+			gy := lum(x-1, y+1) + 2*lum(x, y+1) + lum(x+1, y+1) -
+				lum(x-1, y-1) - 2*lum(x, y-1) - lum(x+1, y-1)
+
+			importance[y][x] = math.Hypot(gx, gy)
+			max = math.Max(max, importance[y][x])
+		}
+	}
+
+	if max > 0 {
+		for y := range importance {
+			for x := range importance[y] {
+				importance[y][x] /= max
+			}
+		}
+	}
+
+	return importance, nil
+}
+
+// halftoneModules returns which modules of the bitmap are drawn with the mask
+// image around their core, the others being solid, see Option.Saliency.
+//
+// A data module at risk, of a colour other than the mask image around it, is
+// drawn with the mask image only if important enough: in every block, the
+// modules at risk are taken in order of decreasing importance as long as the
+// codewords holding them stay within the error budget of the block, less the
+// codewords under the logo. The modules agreeing with the mask image are always
+// drawn with it.
+func (q *HalftoneQRCode) halftoneModules(sourceImage image.Image) ([][]bool, error) {
+	budget := q.option.ErrorBudget
+	switch {
+	case q.option.NoErrorBudget:
+		budget = 0
+	case budget == 0:
+		budget = defaultErrorBudget
+	case budget < 0 || budget > 1:
+		return nil, errors.New("error budget must be between 0 and 1")
+	}
+
+	size := q.symbol.size
+
+	// The mask image reduced to a pixel per module.
+	small, err := q.getMaskAreaImage(sourceImage, image.Rect(0, 0, size, size))
+	if err != nil {
+		return nil, err
+	}
+
+	importance, err := q.importanceMap(small)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmap := q.symbol.bitmap()
+
+	halftone := make([][]bool, size)
+	for y := range halftone {
+		halftone[y] = make([]bool, size)
+
+		for x := range halftone[y] {
+			switch {
+			case q.inQuietZone(x, y):
+				halftone[y][x] = q.option.QuietZoneImage
+			case q.isDataModule(x, y):
+				halftone[y][x] = q.isForeground(small.At(x, y)) == bitmap[y][x]
+			default:
+				halftone[y][x] = q.option.BlendFunctionPatterns
+			}
+		}
+	}
+
+	hasLogo := q.option.LogoImageFile != nil || q.option.LogoImagePath != ""
+
+	blockModules := q.blockModules()
+	blockID := 0
+
+	for _, b := range q.version.block {
+		for j := 0; j < b.numBlocks; j++ {
+			// The codewords under the logo are lost.
+			usedCodewords := make(map[int]bool)
+			if hasLogo {
+				for k, p := range blockModules[blockID] {
+					if p.In(q.logoArea) {
+						usedCodewords[k/8] = true
+					}
+				}
+			}
+
+			q.spendErrorBudget(halftone, importance, blockModules[blockID], usedCodewords,
+				int(budget*float64(q.version.errorCapacity(b))))
+
+			blockID++
+		}
+	}
+
+	return halftone, nil
+}
+
+// spendErrorBudget marks the modules at risk of a block drawn with the mask
+// image, most important first, as long as at most numAllowed codewords of the
+// block hold such modules or are in usedCodewords, the codewords already lost.
+// modules are the modules of every bit of the block.
+func (q *HalftoneQRCode) spendErrorBudget(halftone [][]bool, importance [][]float64, modules []image.Point,
+	usedCodewords map[int]bool, numAllowed int) {
+	quietZone := q.symbol.quietZoneSize
+
+	var atRisk []int
+	for k, p := range modules {
+		bx, by := p.X+quietZone, p.Y+quietZone
+		if !halftone[by][bx] && importance[by][bx] > 0 {
+			atRisk = append(atRisk, k)
+		}
+	}
+
+	sort.SliceStable(atRisk, func(i, j int) bool {
+		pi, pj := modules[atRisk[i]], modules[atRisk[j]]
+		return importance[pi.Y+quietZone][pi.X+quietZone] > importance[pj.Y+quietZone][pj.X+quietZone]
+	})
+
+	for _, k := range atRisk {
+		if !usedCodewords[k/8] {
+			if len(usedCodewords) >= numAllowed {
+				continue
+			}
+
+			usedCodewords[k/8] = true
+		}
+
+		p := modules[k]
+		halftone[p.Y+quietZone][p.X+quietZone] = true
+	}
+}
@@ -0,0 +1,230 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestImportanceMap(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 and the quiet zones are 29 modules wide, the left 14 black.
+	small := image.NewGray(image.Rect(0, 0, 29, 29))
+	for y := 0; y < 29; y++ {
+		for x := 14; x < 29; x++ {
+			small.Set(x, y, color.White)
+		}
+	}
+
+	importance, err := q.importanceMap(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := range importance {
+		for x, v := range importance[y] {
+			expected := 0.0
+			if x == 13 || x == 14 {
+				expected = 1
+			}
+
+			if v != expected {
+				t.Fatalf("module (%d, %d) got importance %f, expected %f", x, y, v, expected)
+			}
+		}
+	}
+
+	weight := image.NewGray(image.Rect(0, 0, 50, 50))
+	for i := range weight.Pix {
+		weight.Pix[i] = 0x80
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, weight)
+	q.AddOption(Option{WeightImageFile: &buf})
+
+	if importance, err = q.importanceMap(small); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := importance[5][5]; math.Abs(v-0.5) > 0.01 {
+		t.Errorf("got importance %f, expected the weight image luminance", v)
+	}
+}
+
+func TestHalftoneModules(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mask, _, err := image.Decode(bytes.NewReader(discImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := q.symbol.size
+	small, err := q.getMaskAreaImage(mask, image.Rect(0, 0, size, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bitmap := q.Bitmap()
+	numAtRisk := make(map[float64]int)
+
+	for _, budget := range []float64{0.25, 1} {
+		q.AddOption(Option{Saliency: true, ErrorBudget: budget})
+
+		halftone, err := q.halftoneModules(mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for y := range halftone {
+			for x, v := range halftone[y] {
+				agrees := q.isForeground(small.At(x, y)) == bitmap[y][x]
+
+				if q.inQuietZone(x, y) && v {
+					t.Errorf("quiet zone module (%d, %d) is not solid", x, y)
+				} else if q.symbol.isUsed[y][x] && !q.isDataModule(x, y) && v {
+					t.Errorf("function pattern module (%d, %d) is not solid", x, y)
+				} else if q.isDataModule(x, y) && agrees && !v {
+					t.Errorf("module (%d, %d) agreeing with the image is solid", x, y)
+				}
+			}
+		}
+
+		// Count the codewords at risk in every block.
+		blockID := 0
+		for i, modules := range q.blockModules() {
+			if i == q.version.block[0].numBlocks {
+				blockID++
+			}
+			b := q.version.block[blockID]
+
+			atRisk := make(map[int]bool)
+			for k, p := range modules {
+				x, y := p.X+1, p.Y+1
+				if halftone[y][x] && q.isForeground(small.At(x, y)) != bitmap[y][x] {
+					atRisk[k/8] = true
+					numAtRisk[budget]++
+				}
+			}
+
+			if numAllowed := int(budget * float64(q.version.errorCapacity(b))); len(atRisk) > numAllowed {
+				t.Errorf("budget %f block %d got %d codewords at risk, expected at most %d", budget, i,
+					len(atRisk), numAllowed)
+			}
+		}
+	}
+
+	if numAtRisk[0.25] >= numAtRisk[1] {
+		t.Errorf("got %d modules at risk with budget 0.25 and %d with 1, expected more with 1",
+			numAtRisk[0.25], numAtRisk[1])
+	}
+
+	// No module at risk without budget.
+	q.AddOption(Option{NoErrorBudget: true})
+
+	halftone, err := q.halftoneModules(mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := range halftone {
+		for x, v := range halftone[y] {
+			if q.isDataModule(x, y) && v && q.isForeground(small.At(x, y)) != bitmap[y][x] {
+				t.Fatalf("module (%d, %d) at risk without budget is not solid", x, y)
+			}
+		}
+	}
+}
+
+func TestHalftoneQRCodeSaliency(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(discImage()), Saliency: true})
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected success", err.Error())
+	}
+
+	q.AddOption(Option{ErrorBudget: 2})
+
+	if _, err := q.CodeImage(3); err == nil {
+		t.Error("budget 2 got success, expected error")
+	}
+}
+
+func TestHalftoneQRCodeSaliencyLogo(t *testing.T) {
+	q, err := NewHalftoneCodeWithOption("http://www.example.org", Medium, EncodeOption{LogoSize: 0.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(discImage()), Saliency: true,
+		LogoImageFile: bytes.NewReader(testLogoImage())})
+
+	mask, _, err := image.Decode(bytes.NewReader(discImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := q.symbol.size
+	small, err := q.getMaskAreaImage(mask, image.Rect(0, 0, size, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	halftone, err := q.halftoneModules(mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The codewords under the logo and those at risk share the error budget.
+	bitmap := q.Bitmap()
+	blockID := 0
+	for i, modules := range q.blockModules() {
+		if i == q.version.block[0].numBlocks {
+			blockID++
+		}
+		b := q.version.block[blockID]
+
+		used := make(map[int]bool)
+		for k, p := range modules {
+			x, y := p.X+q.symbol.quietZoneSize, p.Y+q.symbol.quietZoneSize
+			if p.In(q.logoArea) || halftone[y][x] && q.isForeground(small.At(x, y)) != bitmap[y][x] {
+				used[k/8] = true
+			}
+		}
+
+		if numAllowed := int(defaultErrorBudget * float64(q.version.errorCapacity(b))); len(used) > numAllowed {
+			t.Errorf("block %d got %d codewords under the logo or at risk, expected at most %d", i, len(used),
+				numAllowed)
+		}
+	}
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Verify(img); err != nil {
+		t.Errorf("got %s, expected success", err.Error())
+	}
+}
@@ -0,0 +1,242 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/vector"
+)
+
+// ModuleShape draws the shape of the modules, see Option.DataModuleShape.
+type ModuleShape interface {
+	// Outline adds the closed outline of the shape of a module filling r to p.
+	// joined tells which neighbours of the module are solid modules of the
+	// same colour, for the shapes connecting to them.
+	Outline(p Pen, r image.Rectangle, joined Neighbours)
+}
+
+// Pen is the path drawing the outline of a module shape, anti-aliased. The
+// points are in pixels, the segments added after MoveTo until ClosePath form a
+// closed outline filled with the non-zero rule.
+type Pen interface {
+	MoveTo(ax, ay float32)
+	LineTo(bx, by float32)
+	QuadTo(bx, by, cx, cy float32)
+	CubeTo(bx, by, cx, cy, dx, dy float32)
+	ClosePath()
+}
+
+// Neighbours tells, for each side of a module, whether the module next to it
+// is joined.
+type Neighbours struct {
+	Top, Right, Bottom, Left bool
+}
+
+// Module shapes.
+var (
+	// SquareShape fills the whole module, as the modules are drawn by default.
+	SquareShape ModuleShape = roundedShape{}
+
+	// CircleShape draws dots.
+	CircleShape ModuleShape = roundedShape{radius: 0.5}
+
+	// RoundedShape draws squares with rounded corners.
+	RoundedShape ModuleShape = roundedShape{radius: 0.25}
+
+	// DiamondShape draws squares rotated by 45 degrees.
+	DiamondShape ModuleShape = diamondShape{}
+
+	// HorizontalBarShape connects the horizontal runs of modules into bars
+	// with rounded ends, VerticalBarShape the vertical runs.
+	HorizontalBarShape ModuleShape = barShape{}
+	VerticalBarShape   ModuleShape = barShape{vertical: true}
+
+	// LiquidShape draws dots merging with their neighbours, only the outer
+	// corners of the groups of modules being rounded.
+	LiquidShape ModuleShape = roundedShape{radius: 0.5, liquid: true}
+)
+
+// kappa is the distance of the control points of a cubic Bézier curve
+// approximating a quarter circle of radius 1.
+const kappa = 0.5522847
+
+// barInset is the fraction of the width of a module left on both sides of the
+// bars.
+const barInset = 0.1
+
+// roundedShape is a square with corners rounded by radius times the module
+// width. The liquid shape only rounds the corners without joined neighbour on
+// either side.
+type roundedShape struct {
+	radius float32
+	liquid bool
+}
+
+func (s roundedShape) Outline(p Pen, r image.Rectangle, joined Neighbours) {
+	radius := s.radius * float32(minInt(r.Dx(), r.Dy()))
+
+	// Top left, top right, bottom right and bottom left corners.
+	radii := [4]float32{radius, radius, radius, radius}
+	if s.liquid {
+		if joined.Top || joined.Left {
+			radii[0] = 0
+		}
+		if joined.Top || joined.Right {
+			radii[1] = 0
+		}
+		if joined.Bottom || joined.Right {
+			radii[2] = 0
+		}
+		if joined.Bottom || joined.Left {
+			radii[3] = 0
+		}
+	}
+
+	roundedRect(p, float32(r.Min.X), float32(r.Min.Y), float32(r.Max.X), float32(r.Max.Y), radii)
+}
+
+type diamondShape struct{}
+
+func (diamondShape) Outline(p Pen, r image.Rectangle, joined Neighbours) {
+	x0, y0, x1, y1 := float32(r.Min.X), float32(r.Min.Y), float32(r.Max.X), float32(r.Max.Y)
+	mx, my := (x0+x1)/2, (y0+y1)/2
+
+	p.MoveTo(mx, y0)
+	p.LineTo(x1, my)
+	p.LineTo(mx, y1)
+	p.LineTo(x0, my)
+	p.ClosePath()
+}
+
+// barShape is the slice of a bar a module is part of, reaching the sides of the
+// module joined along the bar and rounded at the others.
+type barShape struct {
+	vertical bool
+}
+
+func (s barShape) Outline(p Pen, r image.Rectangle, joined Neighbours) {
+	x0, y0, x1, y1 := float32(r.Min.X), float32(r.Min.Y), float32(r.Max.X), float32(r.Max.Y)
+
+	if s.vertical {
+		inset := barInset * (x1 - x0)
+		x0, x1 = x0+inset, x1-inset
+
+		radius := (x1 - x0) / 2
+		top, bottom := radius, radius
+		if joined.Top {
+			top = 0
+		}
+		if joined.Bottom {
+			bottom = 0
+		}
+
+		roundedRect(p, x0, y0, x1, y1, [4]float32{top, top, bottom, bottom})
+		return
+	}
+
+	inset := barInset * (y1 - y0)
+	y0, y1 = y0+inset, y1-inset
+
+	radius := (y1 - y0) / 2
+	left, right := radius, radius
+	if joined.Left {
+		left = 0
+	}
+	if joined.Right {
+		right = 0
+	}
+
+	roundedRect(p, x0, y0, x1, y1, [4]float32{left, right, right, left})
+}
+
+// roundedRect adds the outline of a rectangle to p, with the top left, top
+// right, bottom right and bottom left corners rounded by the given radii.
+func roundedRect(p Pen, x0, y0, x1, y1 float32, radii [4]float32) {
+	tl, tr, br, bl := radii[0], radii[1], radii[2], radii[3]
+
+	p.MoveTo(x0+tl, y0)
+	p.LineTo(x1-tr, y0)
+	if tr > 0 {
+		p.CubeTo(x1-tr+kappa*tr, y0, x1, y0+tr-kappa*tr, x1, y0+tr)
+	}
+	p.LineTo(x1, y1-br)
+	if br > 0 {
+		p.CubeTo(x1, y1-br+kappa*br, x1-br+kappa*br, y1, x1-br, y1)
+	}
+	p.LineTo(x0+bl, y1)
+	if bl > 0 {
+		p.CubeTo(x0+bl-kappa*bl, y1, x0, y1-bl+kappa*bl, x0, y1-bl)
+	}
+	p.LineTo(x0, y0+tl)
+	if tl > 0 {
+		p.CubeTo(x0, y0+tl-kappa*tl, x0+tl-kappa*tl, y0, x0+tl, y0)
+	}
+	p.ClosePath()
+}
+
+// moduleShape returns the shape of the module at x, y of the bitmap, nil for
+// plain squares.
+func (q *HalftoneQRCode) moduleShape(x, y int) ModuleShape {
+	if q.isDataModule(x, y) || !q.symbol.isUsed[y][x] {
+		return q.option.DataModuleShape
+	}
+
+	return q.option.FunctionModuleShape
+}
+
+// joinedNeighbours returns which neighbours of the solid module at x, y of the
+// bitmap are solid modules of the same colour.
+func joinedNeighbours(bitmap [][]bool, showMask [][]bool, x, y int) Neighbours {
+	joined := func(nx, ny int) bool {
+		return ny >= 0 && ny < len(bitmap) && nx >= 0 && nx < len(bitmap[ny]) &&
+			!showMask[ny][nx] && bitmap[ny][nx] == bitmap[y][x]
+	}
+
+	return Neighbours{
+		Top:    joined(x, y-1),
+		Right:  joined(x+1, y),
+		Bottom: joined(x, y+1),
+		Left:   joined(x-1, y),
+	}
+}
+
+// drawModuleShape draws shape filling r of dst with c, anti-aliased. c replaces
+// the colour of dst within the shape, even if transparent. z is reused from
+// module to module.
+func drawModuleShape(dst draw.Image, z *vector.Rasterizer, r image.Rectangle, c color.Color, shape ModuleShape,
+	joined Neighbours) {
+	z.Reset(r.Dx(), r.Dy())
+	z.DrawOp = draw.Src
+
+	shape.Outline(z, image.Rect(0, 0, r.Dx(), r.Dy()), joined)
+
+	coverage := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	z.Draw(coverage, coverage.Rect, image.Opaque, image.Point{})
+
+	// Interpolate between the colours of dst and c, premultiplied, by the
+	// coverage: compositing c over dst would keep dst under a transparent c.
+	cr, cg, cb, ca := c.RGBA()
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			m := uint32(coverage.AlphaAt(x, y).A) * 0x101
+			if m == 0 {
+				continue
+			}
+
+			dr, dg, db, da := dst.At(r.Min.X+x, r.Min.Y+y).RGBA()
+			lerp := func(s uint32, d uint32) uint16 {
+				return uint16((s*m + d*(0xffff-m)) / 0xffff)
+			}
+
+			dst.Set(r.Min.X+x, r.Min.Y+y, color.RGBA64{lerp(cr, dr), lerp(cg, dg), lerp(cb, db), lerp(ca, da)})
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
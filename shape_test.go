@@ -0,0 +1,149 @@
+package qart
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/vector"
+)
+
+func TestModuleShapes(t *testing.T) {
+	const moduleWidth = 9
+
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	square, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bitmap := q.Bitmap()
+
+	gray := func(img image.Image, x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+	}
+
+	// The dark data modules with a dark module on their right.
+	var runs []image.Point
+	for y, row := range bitmap {
+		for x := range row[:len(row)-1] {
+			if row[x] && row[x+1] && q.isDataModule(x, y) && q.isDataModule(x+1, y) {
+				runs = append(runs, image.Pt(x, y))
+			}
+		}
+	}
+
+	if len(runs) == 0 {
+		t.Fatal("got no horizontal run of dark data modules, expected some")
+	}
+
+	tests := []struct {
+		shape ModuleShape
+
+		// Whether the top left corner of the module and the middle of its right
+		// side are mostly light.
+		topLeft, right bool
+	}{
+		{SquareShape, false, false},
+		{CircleShape, true, false},
+		{RoundedShape, true, false},
+		{DiamondShape, true, false},
+		{HorizontalBarShape, true, false},
+	}
+
+	for i, test := range tests {
+		q.AddOption(Option{DataModuleShape: test.shape})
+
+		img, err := q.CodeImage(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := runs[0].Mul(moduleWidth)
+
+		for _, pixel := range []struct {
+			name     string
+			x, y     int
+			expected bool
+		}{
+			{"top left", p.X, p.Y, test.topLeft},
+			{"right", p.X + moduleWidth - 1, p.Y + moduleWidth/2, test.right},
+		} {
+			if got := gray(img, pixel.x, pixel.y); (got > 0x80) != pixel.expected {
+				t.Errorf("shape %d: got %s pixel %d, expected light %t", i, pixel.name, got, pixel.expected)
+			}
+		}
+
+		// The finder patterns keep their shape.
+		quietZone := q.symbol.quietZoneSize * moduleWidth
+		if got := gray(img, quietZone, quietZone); got != 0 {
+			t.Errorf("shape %d: got finder pattern corner %d, expected 0", i, got)
+		}
+
+		if test.shape == SquareShape {
+			for y := 0; y < img.Bounds().Dy(); y++ {
+				for x := 0; x < img.Bounds().Dx(); x++ {
+					if gray(img, x, y) != gray(square, x, y) {
+						t.Fatalf("got pixel %d at %d,%d, expected %d as without shape", gray(img, x, y), x, y,
+							gray(square, x, y))
+					}
+				}
+			}
+		}
+
+		// The edges are anti-aliased.
+		if test.shape == CircleShape {
+			if got := gray(img, p.X+1, p.Y+1); got == 0 || got == 0xff {
+				t.Errorf("got pixel %d near the corner of a dot, expected grey", got)
+			}
+		}
+	}
+}
+
+func TestModuleShapesJoined(t *testing.T) {
+	tests := []struct {
+		shape    ModuleShape
+		joined   Neighbours
+		expected []image.Point
+		empty    []image.Point
+	}{
+		{
+			HorizontalBarShape,
+			Neighbours{Left: true},
+			[]image.Point{{0, 5}, {3, 5}},
+			[]image.Point{{9, 1}, {5, 0}, {5, 9}},
+		},
+		{
+			VerticalBarShape,
+			Neighbours{Top: true, Bottom: true},
+			[]image.Point{{5, 0}, {5, 9}},
+			[]image.Point{{0, 5}, {9, 5}},
+		},
+		{
+			LiquidShape,
+			Neighbours{Top: true},
+			[]image.Point{{0, 0}, {9, 0}},
+			[]image.Point{{0, 9}, {9, 9}},
+		},
+	}
+
+	for i, test := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		drawModuleShape(img, vector.NewRasterizer(10, 10), img.Rect, color.Black, test.shape, test.joined)
+
+		for _, p := range test.expected {
+			if a := img.RGBAAt(p.X, p.Y).A; a != 0xff {
+				t.Errorf("shape %d: got alpha %d at %v, expected filled", i, a, p)
+			}
+		}
+		for _, p := range test.empty {
+			if a := img.RGBAAt(p.X, p.Y).A; a > 0x40 {
+				t.Errorf("shape %d: got alpha %d at %v, expected empty", i, a, p)
+			}
+		}
+	}
+}
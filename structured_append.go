@@ -0,0 +1,335 @@
+package qart
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"unicode/utf8"
+
+	"github.com/xrlin/qart/bitset"
+)
+
+// maxStructuredAppendSymbols is the maximum number of symbols in a Structured
+// Append sequence.
+const maxStructuredAppendSymbols = 16
+
+// structuredAppend is the header linking a symbol into a Structured Append
+// sequence.
+type structuredAppend struct {
+	// Position of the symbol in the sequence (0-15).
+	index int
+
+	// Number of symbols in the sequence (1-16).
+	total int
+
+	// XOR of all the bytes of the complete content.
+	parity byte
+}
+
+// appendHeader appends the Structured Append header to encoded: the mode
+// indicator (0011), the 4-bit symbol index, the 4-bit total number of symbols
+// minus one and the 8-bit parity.
+func (s *structuredAppend) appendHeader(encoded *bitset.Bitset) {
+	encoded.Append(bitset.New(b0, b0, b1, b1))
+	encoded.AppendUint32(uint32(s.index), 4)
+	encoded.AppendUint32(uint32(s.total-1), 4)
+	encoded.AppendByte(s.parity, 8)
+}
+
+// StructuredAppend is content split across up to 16 linked symbols. Readers
+// supporting Structured Append concatenate the content of all the symbols.
+type StructuredAppend struct {
+	// Original content encoded.
+	Content string
+
+	// Symbols of the sequence, in order.
+	Codes []*HalftoneQRCode
+}
+
+// NewStructuredAppend constructs the shortest sequence of symbols holding
+// content.
+//
+// Content which fits in a single symbol produces a single regular symbol
+// without a Structured Append header.
+//
+// An error occurs if the content does not fit in 16 symbols.
+func NewStructuredAppend(content string, level RecoveryLevel) (*StructuredAppend, error) {
+	return NewStructuredAppendWithOption(content, level, EncodeOption{})
+}
+
+// NewStructuredAppendWithOption constructs the shortest sequence of symbols
+// holding content, encoding each part according to opt.
+//
+// An error occurs if the content does not fit in 16 symbols.
+func NewStructuredAppendWithOption(content string, level RecoveryLevel, opt EncodeOption) (*StructuredAppend, error) {
+	q, err := NewHalftoneCodeWithOption(content, level, opt)
+	if err == nil {
+		return &StructuredAppend{Content: content, Codes: []*HalftoneQRCode{q}}, nil
+	} else if !isTooLong(err) {
+		return nil, err
+	}
+
+	data := []byte(content)
+
+	var parity byte
+	for _, v := range data {
+		parity ^= v
+	}
+
+	boundaries := characterBoundaries(data)
+
+	for total := 2; total <= maxStructuredAppendSymbols && total <= len(boundaries); total++ {
+		parts := splitAt(data, boundaries, total)
+
+		// Check every part fits before building the symbols.
+		fits := true
+		for index, part := range parts {
+			sa := &structuredAppend{index: index, total: total, parity: parity}
+
+			if _, _, _, err := encodingChooser(opt)(part, level, opt, sa); isTooLong(err) {
+				fits = false
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		if !fits {
+			continue
+		}
+
+		codes := make([]*HalftoneQRCode, total)
+		for index, part := range parts {
+			sa := &structuredAppend{index: index, total: total, parity: parity}
+
+			q, err := newHalftoneCode(part, level, opt, sa)
+			if err != nil {
+				return nil, err
+			}
+
+			codes[index] = q
+		}
+
+		return &StructuredAppend{Content: content, Codes: codes}, nil
+	}
+
+	return nil, errors.New("content too long to encode in 16 symbols")
+}
+
+// isTooLong reports whether err is returned for content too long for a symbol,
+// or for its logo.
+func isTooLong(err error) bool {
+	switch err.(type) {
+	case *CapacityError, *LogoError:
+		return true
+	}
+
+	return false
+}
+
+// characterBoundaries returns the offsets in data at which a character starts,
+// so that multi-byte UTF-8 and Shift JIS characters are not split between two
+// symbols.
+func characterBoundaries(data []byte) []int {
+	var boundaries []int
+
+	switch {
+	case utf8.Valid(data):
+		for i := range string(data) {
+			boundaries = append(boundaries, i)
+		}
+	case isShiftJIS(data):
+		for i := 0; i < len(data); i++ {
+			boundaries = append(boundaries, i)
+
+			if v := data[i]; (v >= 0x81 && v <= 0x9f) || (v >= 0xe0 && v <= 0xfc) {
+				i++
+			}
+		}
+	default:
+		for i := range data {
+			boundaries = append(boundaries, i)
+		}
+	}
+
+	return boundaries
+}
+
+// splitAt splits data into n parts of about the same encoded length, cutting
+// it at character boundaries. Every part holds a character at least.
+func splitAt(data []byte, boundaries []int, n int) []string {
+	costs := encodedCosts(data, boundaries)
+	numBoundaries := len(boundaries)
+
+	parts := make([]string, n)
+
+	// Index of the boundary starting the part.
+	start := 0
+	for i := range parts {
+		end := numBoundaries
+		if i < n-1 {
+			target := costs[numBoundaries] * (i + 1) / n
+
+			// The cut comes after the start of the part, and leaves a
+			// character for every part after.
+			end = start + 1
+			for end+1 <= numBoundaries-(n-1-i) && abs(costs[end+1]-target) < abs(costs[end]-target) {
+				end++
+			}
+		}
+
+		parts[i] = string(data[boundaryOffset(data, boundaries, start):boundaryOffset(data, boundaries, end)])
+		start = end
+	}
+
+	return parts
+}
+
+// boundaryOffset returns the offset in data of the boundary i, the end of data
+// after the last.
+func boundaryOffset(data []byte, boundaries []int, i int) int {
+	if i == len(boundaries) {
+		return len(data)
+	}
+	return boundaries[i]
+}
+
+// encodedCosts returns the length, in sixths of a bit, of data encoded up to
+// every boundary and up to its end, each character costing as in the mode
+// encoding it alone. The segment headers are left out.
+func encodedCosts(data []byte, boundaries []int) []int {
+	kanji := !utf8.Valid(data) && isShiftJIS(data)
+
+	costs := make([]int, len(boundaries)+1)
+	for i := range boundaries {
+		c := data[boundaries[i]:boundaryOffset(data, boundaries, i+1)]
+
+		cost := 48 * len(c)
+		switch {
+		case kanji && isKanjiCharacter(c):
+			cost = 78
+		case len(c) == 1 && isNumericCharacter(c[0]):
+			cost = 20
+		case len(c) == 1 && isAlphanumericCharacter(c[0]):
+			cost = 33
+		}
+
+		costs[i+1] = costs[i] + cost
+	}
+
+	return costs
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+// AddOption adds cfg to every symbol of the sequence, see
+// HalftoneQRCode.AddOption.
+func (s *StructuredAppend) AddOption(cfg Option) *StructuredAppend {
+	for _, q := range s.Codes {
+		q.AddOption(cfg)
+	}
+
+	return s
+}
+
+// CodeImages generates every symbol of the sequence as a separate image,
+// pointWidth is as for HalftoneQRCode.CodeImage.
+func (s *StructuredAppend) CodeImages(pointWidth int) (ret []image.Image, err error) {
+	for _, q := range s.Codes {
+		var img image.Image
+
+		img, err = q.CodeImage(pointWidth)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, img)
+	}
+
+	return
+}
+
+// GridImage lays the symbols of the sequence out in a grid with the given
+// number of columns, in reading order. pointWidth is as for CodeImages.
+func (s *StructuredAppend) GridImage(pointWidth int, columns int) (image.Image, error) {
+	if columns < 1 {
+		return nil, errors.New("grid must have at least one column")
+	}
+
+	images, err := s.CodeImages(pointWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	cell := maxImageSize(images)
+
+	rows := (len(images) + columns - 1) / columns
+	if len(images) < columns {
+		columns = len(images)
+	}
+
+	grid := image.NewRGBA(image.Rect(0, 0, columns*cell.X, rows*cell.Y))
+	draw.Draw(grid, grid.Rect, image.NewUniform(s.Codes[0].option.BackgroundColor), image.Point{}, draw.Src)
+
+	for i, img := range images {
+		// Center smaller symbols in their cell.
+		size := img.Bounds().Size()
+		min := image.Pt((i%columns)*cell.X+(cell.X-size.X)/2, (i/columns)*cell.Y+(cell.Y-size.Y)/2)
+
+		draw.Draw(grid, image.Rectangle{Min: min, Max: min.Add(size)}, img, img.Bounds().Min, draw.Src)
+	}
+
+	return grid, nil
+}
+
+// CodeGif generates the symbols of the sequence as the frames of a gif, each
+// shown for delay 100ths of a second. pointWidth is as for CodeImages.
+func (s *StructuredAppend) CodeGif(pointWidth int, delay int) (*gif.GIF, error) {
+	images, err := s.CodeImages(pointWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	size := maxImageSize(images)
+	rect := image.Rectangle{Max: size}
+
+	ret := &gif.GIF{Config: image.Config{Width: size.X, Height: size.Y}}
+
+	for i, img := range images {
+		frame := image.NewRGBA(rect)
+		draw.Draw(frame, rect, image.NewUniform(s.Codes[i].option.BackgroundColor), image.Point{}, draw.Src)
+
+		imgSize := img.Bounds().Size()
+		min := image.Pt((size.X-imgSize.X)/2, (size.Y-imgSize.Y)/2)
+		draw.Draw(frame, image.Rectangle{Min: min, Max: min.Add(imgSize)}, img, img.Bounds().Min, draw.Src)
+
+		ret.Image = append(ret.Image, gifFrame(frame, s.Codes[i].gifColors()))
+		ret.Delay = append(ret.Delay, delay)
+	}
+
+	return ret, nil
+}
+
+// maxImageSize returns the largest width and height of images.
+func maxImageSize(images []image.Image) image.Point {
+	var size image.Point
+
+	for _, img := range images {
+		if s := img.Bounds().Size(); s.X > size.X {
+			size.X = s.X
+		}
+
+		if s := img.Bounds().Size(); s.Y > size.Y {
+			size.Y = s.Y
+		}
+	}
+
+	return size
+}
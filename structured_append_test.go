@@ -0,0 +1,183 @@
+package qart
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xrlin/qart/bitset"
+)
+
+func TestStructuredAppendHeader(t *testing.T) {
+	encoded := bitset.New()
+
+	sa := &structuredAppend{index: 2, total: 4, parity: 0xa5}
+	sa.appendHeader(encoded)
+
+	expected := bitset.NewFromBase2String("0011 0010 0011 10100101")
+	if !expected.Equals(encoded) {
+		t.Errorf("got %s, expected %s", encoded.String(), expected.String())
+	}
+}
+
+func TestNewStructuredAppend(t *testing.T) {
+	tests := []struct {
+		content       string
+		level         RecoveryLevel
+		expectedCodes int
+	}{
+		{
+			"http://example.com",
+			Medium,
+			1,
+		},
+		{
+			strings.Repeat("#", 2954),
+			Low,
+			2,
+		},
+		{
+			strings.Repeat("#", 1274*5),
+			Highest,
+			6,
+		},
+		{
+			strings.Repeat("€", 1500),
+			Low,
+			2,
+		},
+	}
+
+	for _, test := range tests {
+		s, err := NewStructuredAppend(test.content, test.level)
+		if err != nil {
+			t.Errorf("%d bytes got %s, expected success", len(test.content), err.Error())
+			continue
+		}
+
+		if len(s.Codes) != test.expectedCodes {
+			t.Errorf("%d bytes split into %d symbols, expected %d", len(test.content),
+				len(s.Codes), test.expectedCodes)
+			continue
+		}
+
+		var content string
+		for _, q := range s.Codes {
+			content += q.Content
+		}
+
+		if content != test.content {
+			t.Errorf("%d bytes split into symbols holding %d bytes", len(test.content),
+				len(content))
+		}
+
+		if len(s.Codes) == 1 {
+			continue
+		}
+
+		var parity byte
+		for _, v := range []byte(test.content) {
+			parity ^= v
+		}
+
+		for i, q := range s.Codes {
+			sa := q.encoder.structuredAppend
+			if sa == nil || sa.index != i || sa.total != len(s.Codes) || sa.parity != parity {
+				t.Errorf("symbol %d has header %+v, expected index %d of %d with parity %#x",
+					i, sa, i, len(s.Codes), parity)
+			}
+		}
+	}
+
+	if _, err := NewStructuredAppend(strings.Repeat("#", 2953*16+1), Low); err == nil {
+		t.Error("content longer than 16 symbols encodable, expected error")
+	}
+}
+
+func TestSplitAt(t *testing.T) {
+	tests := []struct {
+		content  string
+		n        int
+		expected []string
+	}{
+		{"abcd", 2, []string{"ab", "cd"}},
+		// Every part holds a character.
+		{"a😀😀b", 4, []string{"a", "😀", "😀", "b"}},
+		{"a😀😀b", 3, []string{"a😀", "😀", "b"}},
+		// Digits encode in fewer bits than lowercase letters.
+		{"123456789012abcdefgh", 2, []string{"123456789012a", "bcdefgh"}},
+	}
+
+	for _, test := range tests {
+		data := []byte(test.content)
+
+		parts := splitAt(data, characterBoundaries(data), test.n)
+		if strings.Join(parts, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%q split into %d got %q, expected %q", test.content, test.n, parts, test.expected)
+		}
+	}
+}
+
+func TestStructuredAppendLogo(t *testing.T) {
+	content := strings.Repeat("#", 3000)
+
+	s, err := NewStructuredAppendWithOption(content, Low, EncodeOption{LogoSize: 0.3})
+	if err != nil {
+		t.Fatalf("got %s, expected success", err.Error())
+	}
+
+	// The logo takes more symbols than the 2 holding the content without.
+	if len(s.Codes) <= 2 {
+		t.Errorf("got %d symbols, expected more than 2", len(s.Codes))
+	}
+
+	for i, q := range s.Codes {
+		if !logoFits(q.version, q.logoArea) {
+			t.Errorf("symbol %d got logo area %v not corrected by version %d level %d", i, q.logoArea,
+				q.VersionNumber, q.Level)
+		}
+	}
+
+	if _, err := NewStructuredAppendWithOption(content, Low, EncodeOption{LogoSize: 0.6}); err != ErrInvalidLogoSize {
+		t.Errorf("got %v, expected %s", err, ErrInvalidLogoSize.Error())
+	}
+}
+
+func TestStructuredAppendImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping TestStructuredAppendImages")
+	}
+
+	s, err := NewStructuredAppend(strings.Repeat("#", 1274*2), Highest)
+	if err != nil {
+		t.Fatalf("got %s, expected success", err.Error())
+	}
+
+	if len(s.Codes) != 3 {
+		t.Fatalf("split into %d symbols, expected 3", len(s.Codes))
+	}
+
+	images, err := s.CodeImages(3)
+	if err != nil {
+		t.Fatalf("CodeImages got %s, expected success", err.Error())
+	}
+
+	cell := images[0].Bounds().Size()
+
+	grid, err := s.GridImage(3, 2)
+	if err != nil {
+		t.Fatalf("GridImage got %s, expected success", err.Error())
+	}
+
+	if size := grid.Bounds().Size(); size.X != 2*cell.X || size.Y != 2*cell.Y {
+		t.Errorf("grid has size %v, expected %dx%d", size, 2*cell.X, 2*cell.Y)
+	}
+
+	g, err := s.CodeGif(3, 100)
+	if err != nil {
+		t.Fatalf("CodeGif got %s, expected success", err.Error())
+	}
+
+	if len(g.Image) != 3 || len(g.Delay) != 3 {
+		t.Errorf("gif has %d frames and %d delays, expected 3", len(g.Image), len(g.Delay))
+	}
+}
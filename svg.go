@@ -0,0 +1,114 @@
+package qart
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// CodeSVG generates the code as an SVG vector image, pointWidth is as for
+// CodeImage.
+//
+// The modules and module cores are drawn as paths, horizontal runs of modules
+// merged. The mask image is embedded as a PNG behind them, or linked to
+// MaskImagePath with the LinkMaskImage option. In Embed mode the code is drawn
+// over the whole mask image.
+func (q *HalftoneQRCode) CodeSVG(pointWidth int) ([]byte, error) {
+	v, err := q.layoutVector(pointWidth, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" `+
+		`version="1.1" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", v.width, v.height, v.width, v.height)
+
+	if v.source != nil {
+		// Draw the code over the mask image, scaled to the mask rectangle.
+		bounds := v.source.Bounds()
+		if err := q.writeSVGImage(&buf, v.source, bounds, bounds, bounds.Sub(bounds.Min)); err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&buf, `<g transform="translate(%d %d) scale(%g %g)">`+"\n", v.embedRect.Min.X,
+			v.embedRect.Min.Y, float64(v.embedRect.Dx())/float64(v.size), float64(v.embedRect.Dy())/float64(v.size))
+	}
+
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" %s/>`+"\n", v.size, v.size, svgFill(q.option.BackgroundColor))
+
+	if v.mask != nil {
+		if err := q.writeSVGImage(&buf, v.mask, v.sourceBounds, v.maskArea, v.maskRect); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(v.light) > 0 {
+		fmt.Fprintf(&buf, `<path %s d="%s"/>`+"\n", svgFill(q.option.BackgroundColor), svgPathData(v.light))
+	}
+	if len(v.dark) > 0 {
+		fmt.Fprintf(&buf, `<path %s d="%s"/>`+"\n", svgFill(q.option.ForegroundColor), svgPathData(v.dark))
+	}
+
+	if v.source != nil {
+		buf.WriteString("</g>\n")
+	}
+	buf.WriteString("</svg>\n")
+
+	return buf.Bytes(), nil
+}
+
+// writeSVGImage writes an image element showing img in rect. With the
+// LinkMaskImage option, MaskImagePath is linked instead, showing area of the
+// mask image with the given bounds.
+func (q *HalftoneQRCode) writeSVGImage(buf *bytes.Buffer, img image.Image, bounds image.Rectangle,
+	area image.Rectangle, rect image.Rectangle) error {
+	if q.option.LinkMaskImage && q.option.MaskImagePath != "" {
+		fmt.Fprintf(buf, `<svg x="%d" y="%d" width="%d" height="%d" viewBox="%d %d %d %d" `+
+			`preserveAspectRatio="none">`, rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(), area.Min.X, area.Min.Y, area.Dx(), area.Dy())
+		fmt.Fprintf(buf, `<image x="%d" y="%d" width="%d" height="%d" xlink:href="`, bounds.Min.X, bounds.Min.Y,
+			bounds.Dx(), bounds.Dy())
+		xml.EscapeText(buf, []byte(q.option.MaskImagePath))
+		buf.WriteString("\"/></svg>\n")
+
+		return nil
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(buf, `<image x="%d" y="%d" width="%d" height="%d" preserveAspectRatio="none" `+
+		`xlink:href="data:image/png;base64,%s"/>`+"\n", rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(),
+		base64.StdEncoding.EncodeToString(encoded.Bytes()))
+
+	return nil
+}
+
+// svgPathData returns the path data of the rectangles rects.
+func svgPathData(rects []image.Rectangle) string {
+	var buf bytes.Buffer
+	for _, r := range rects {
+		fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), r.Dx())
+	}
+
+	return buf.String()
+}
+
+// svgFill returns the fill attributes painting with c.
+func svgFill(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, n.R, n.G, n.B)
+	if n.A != 0xff {
+		fill += fmt.Sprintf(` fill-opacity="%g"`, float64(n.A)/0xff)
+	}
+
+	return fill
+}
@@ -0,0 +1,169 @@
+package qart
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// svgDocument is the structure of the SVG images generated.
+type svgDocument struct {
+	Width  int `xml:"width,attr"`
+	Height int `xml:"height,attr"`
+	Images []struct {
+		Href string `xml:"href,attr"`
+	} `xml:"image"`
+	Links []struct {
+		Image struct {
+			Href string `xml:"href,attr"`
+		} `xml:"image"`
+	} `xml:"svg"`
+	Paths []svgPath `xml:"path"`
+	Group struct {
+		Transform string    `xml:"transform,attr"`
+		Paths     []svgPath `xml:"path"`
+	} `xml:"g"`
+}
+
+type svgPath struct {
+	Fill string `xml:"fill,attr"`
+	D    string `xml:"d,attr"`
+}
+
+func parseSVG(t *testing.T, data []byte) *svgDocument {
+	var doc svgDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("got %s, expected valid XML", err.Error())
+	}
+
+	return &doc
+}
+
+func TestCodeSVG(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{ForegroundColor: color.RGBA{0xc0, 0, 0, 0xff}})
+
+	data, err := q.CodeSVG(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := parseSVG(t, data)
+
+	// Version 1 and the quiet zones are 29 modules wide.
+	if doc.Width != 29*9 || doc.Height != 29*9 {
+		t.Errorf("got size %dx%d, expected %d", doc.Width, doc.Height, 29*9)
+	}
+
+	if len(doc.Images) != 0 || len(doc.Paths) != 1 || doc.Paths[0].Fill != "#c00000" {
+		t.Fatalf("got %d images %d paths, expected a single red path", len(doc.Images), len(doc.Paths))
+	}
+
+	// Every horizontal run of dark modules is a rectangle.
+	numRuns := 0
+	for _, row := range q.Bitmap() {
+		for x, v := range row {
+			if v && (x == 0 || !row[x-1]) {
+				numRuns++
+			}
+		}
+	}
+
+	if n := strings.Count(doc.Paths[0].D, "M"); n != numRuns {
+		t.Errorf("got %d rectangles, expected %d", n, numRuns)
+	}
+}
+
+func TestCodeSVGMask(t *testing.T) {
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage())})
+
+	data, err := q.CodeSVG(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := parseSVG(t, data)
+
+	if len(doc.Images) != 1 || !strings.HasPrefix(doc.Images[0].Href, "data:image/png;base64,") {
+		t.Fatal("got no embedded mask image, expected one")
+	}
+
+	if len(doc.Paths) != 2 || doc.Paths[0].Fill != "#ffffff" || doc.Paths[1].Fill != "#000000" {
+		t.Fatalf("got %d paths, expected light and dark paths", len(doc.Paths))
+	}
+
+	// Every data module has its own core.
+	numDark := 0
+	for y, row := range q.Bitmap() {
+		for x, v := range row {
+			if v && q.isDataModule(x, y) {
+				numDark++
+			}
+		}
+	}
+
+	if n := strings.Count(doc.Paths[1].D, "M"); n < numDark {
+		t.Errorf("got %d dark rectangles, expected at least %d", n, numDark)
+	}
+}
+
+func TestCodeSVGLinkAndEmbed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mask.png")
+	if err := ioutil.WriteFile(path, testMaskImage(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewHalftoneCode("hello", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImagePath: path, LinkMaskImage: true})
+
+	data, err := q.CodeSVG(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := parseSVG(t, data)
+
+	if len(doc.Images) != 0 || len(doc.Links) != 1 || doc.Links[0].Image.Href != path {
+		t.Errorf("got %d images %d links, expected a link to %s", len(doc.Images), len(doc.Links), path)
+	}
+
+	q.RemoveOption(LinkMaskImageOpt)
+	q.AddOption(Option{Embed: true, MaskRectangle: image.Rect(50, 50, 150, 150)})
+
+	if data, err = q.CodeSVG(1); err != nil {
+		t.Fatal(err)
+	}
+
+	doc = parseSVG(t, data)
+
+	// The code is drawn over the whole 300x300 mask image.
+	if doc.Width != 300 || len(doc.Images) != 1 || doc.Group.Transform == "" || len(doc.Group.Paths) != 2 {
+		t.Errorf("got width %d %d images transform %q, expected the code over the mask image", doc.Width,
+			len(doc.Images), doc.Group.Transform)
+	}
+}
@@ -0,0 +1,139 @@
+package qart
+
+import (
+	"errors"
+	"image"
+)
+
+// vectorCode is the code laid out for vector output, in pixels of the image
+// CodeImage would draw.
+type vectorCode struct {
+	// Width and height of the drawing.
+	width, height int
+
+	// In Embed mode, the mask image drawn behind the code, and the rectangle
+	// of the drawing the code is scaled into. Otherwise source is nil.
+	source    image.Image
+	embedRect image.Rectangle
+
+	// Width and height of the code, including its padding.
+	size int
+
+	// The mask image processed as for CodeImage, nil without mask image. It
+	// is drawn in maskRect of the code, and shows maskArea of the source mask
+	// image, with the given bounds.
+	mask         image.Image
+	maskRect     image.Rectangle
+	maskArea     image.Rectangle
+	sourceBounds image.Rectangle
+
+	// Rectangles drawn in the foreground and background colours, over the
+	// background colour and the mask image. Horizontal runs of solid modules
+	// are merged.
+	dark, light []image.Rectangle
+}
+
+// layoutVector lays the code out for vector output. The mask image is
+// processed at maskResolution pixels per pixel of the code, for sharper
+// printing.
+func (q *HalftoneQRCode) layoutVector(pointWidth int, maskResolution int) (*vectorCode, error) {
+	srcImg, err := q.readMaskImage()
+	if err != nil {
+		return nil, err
+	}
+
+	moduleWidth, padding, size, err := q.layout(pointWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.checkContrast(q.option.ForegroundColor); err != nil {
+		return nil, err
+	}
+
+	codeWidth := moduleWidth * q.symbol.size
+
+	v := &vectorCode{
+		width:    size,
+		height:   size,
+		size:     size,
+		maskRect: image.Rect(padding, padding, padding+codeWidth, padding+codeWidth),
+	}
+
+	if q.option.Embed {
+		if srcImg == nil {
+			return nil, errors.New("embed mode requires a mask image")
+		}
+
+		v.source = srcImg
+		v.width, v.height = srcImg.Bounds().Dx(), srcImg.Bounds().Dy()
+		v.embedRect = q.option.MaskRectangle.Sub(srcImg.Bounds().Min)
+	}
+
+	v.mask, err = q.getMaskAreaImage(srcImg, image.Rect(0, 0, codeWidth*maskResolution, codeWidth*maskResolution))
+	if err != nil {
+		return nil, err
+	}
+
+	if v.mask != nil {
+		// The area of the mask image shown, see getMaskAreaImage.
+		v.sourceBounds = srcImg.Bounds()
+		v.maskArea = srcImg.Bounds()
+		if !q.option.MaskRectangle.Empty() {
+			v.maskArea = q.option.MaskRectangle
+		}
+	}
+
+	showMask, err := q.maskedModules(srcImg, v.mask != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The quiet zone is drawn in BackgroundColor, see Option.QuietZoneImage.
+	for y := range showMask {
+		for x := range showMask[y] {
+			if q.inQuietZone(x, y) {
+				showMask[y][x] = false
+			}
+		}
+	}
+
+	core := q.moduleCore(moduleWidth)
+
+	for y, row := range q.symbol.bitmap() {
+		runStart := 0
+
+		for x := 0; x <= len(row); x++ {
+			// Close the run of solid modules of the same colour ending at x.
+			if x == len(row) || showMask[y][x] || (x > runStart && row[x] != row[runStart]) {
+				if x > runStart {
+					v.add(row[runStart], image.Rect(runStart*moduleWidth, y*moduleWidth, x*moduleWidth,
+						(y+1)*moduleWidth).Add(v.maskRect.Min))
+				}
+
+				runStart = x
+			}
+
+			if x < len(row) && showMask[y][x] {
+				v.add(row[x], core.Add(image.Pt(x*moduleWidth, y*moduleWidth)).Add(v.maskRect.Min))
+				runStart = x + 1
+			}
+		}
+	}
+
+	// Without mask image, the light modules are the background.
+	if v.mask == nil {
+		v.light = nil
+	}
+
+	return v, nil
+}
+
+// add adds r to the dark or light rectangles.
+func (v *vectorCode) add(dark bool, r image.Rectangle) {
+	if dark {
+		v.dark = append(v.dark, r)
+	} else {
+		v.light = append(v.light, r)
+	}
+}
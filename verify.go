@@ -0,0 +1,221 @@
+package qart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// ScanReport describes how well a rendered code can be scanned: the modules
+// which no longer read as their intended colour, and how close every error
+// correction block is to its correction capacity.
+type ScanReport struct {
+	// Error correction blocks of the symbol, in order.
+	Blocks []BlockReport
+
+	// Number of function pattern modules (finder, alignment and timing patterns,
+	// format and version information) read with the wrong colour.
+	NumFunctionErrors int
+
+	// Number of data modules read with the wrong colour.
+	NumDataErrors int
+}
+
+// BlockReport describes the errors of a single error correction block.
+type BlockReport struct {
+	// Number of data and error correction codewords of the block.
+	NumCodewords int
+
+	// Number of codewords with at least one wrong module.
+	NumErrors int
+
+	// Number of codeword errors the block corrects.
+	Capacity int
+}
+
+// Margin returns the number of additional codeword errors the block is able to
+// correct. A negative margin means the block cannot be decoded.
+func (b BlockReport) Margin() int {
+	return b.Capacity - b.NumErrors
+}
+
+// MinMargin returns the smallest margin of all the blocks.
+func (r *ScanReport) MinMargin() int {
+	min := 0
+	for i, b := range r.Blocks {
+		if i == 0 || b.Margin() < min {
+			min = b.Margin()
+		}
+	}
+
+	return min
+}
+
+// Score returns the scannability score of the code: the smallest fraction of
+// the error correction capacity left unused by any block. A code without
+// errors scores 1, a score below 0 means the code cannot be decoded.
+func (r *ScanReport) Score() float64 {
+	score := 1.0
+	for _, b := range r.Blocks {
+		if s := float64(b.Margin()) / float64(b.Capacity); s < score {
+			score = s
+		}
+	}
+
+	return score
+}
+
+// UnscannableError is returned when a rendered code cannot be decoded.
+type UnscannableError struct {
+	// Report of the errors found.
+	Report *ScanReport
+
+	// Reason the code cannot be decoded.
+	Reason string
+}
+
+func (e *UnscannableError) Error() string {
+	return "code is not scannable: " + e.Reason
+}
+
+// Verify samples img, an image of the code as produced by CodeImage, at every
+// module center and reports the modules read with the wrong colour.
+//
+// Dark and light are told apart by comparing the luminance of each sample with
+// the luminance of ForegroundColor, or the lightest colour ForegroundFill may
+// use, and BackgroundColor. In Embed mode the code is sampled within
+// MaskRectangle.
+//
+// An *UnscannableError is returned along with the report if more codewords of
+// a block are wrong than the block corrects, or if the sampled modules do not
+// decode to the content of the code.
+func (q *HalftoneQRCode) Verify(img image.Image) (*ScanReport, error) {
+	area := img.Bounds()
+	if q.option.Embed {
+		area = q.option.MaskRectangle
+	}
+
+	modules, err := q.sampleModules(img, area)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmap := q.symbol.bitmap()
+	quietZone := q.symbol.quietZoneSize
+
+	report := &ScanReport{}
+
+	// Count the wrong function pattern modules.
+	for y := 0; y < q.symbol.symbolSize; y++ {
+		for x := 0; x < q.symbol.symbolSize; x++ {
+			bx, by := x+quietZone, y+quietZone
+
+			if !q.isDataModule(bx, by) && modules[y][x] != bitmap[by][bx] {
+				report.NumFunctionErrors++
+			}
+		}
+	}
+
+	// Find the codewords with wrong data modules.
+	numCodewords := 0
+	for _, b := range q.version.block {
+		numCodewords += b.numBlocks * b.numCodewords
+	}
+
+	wrongCodeword := make([]bool, numCodewords)
+
+	m := newHalftoneRegularSymbol(q.version, q.mask)
+	m.walkDataModules(numCodewords*8, func(i int, x int, y int) {
+		if modules[y][x] != bitmap[y+quietZone][x+quietZone] {
+			wrongCodeword[i/8] = true
+			report.NumDataErrors++
+		}
+	})
+
+	blocks := codewordBlocks(q.version)
+	blockID := 0
+	for _, b := range q.version.block {
+		for j := 0; j < b.numBlocks; j++ {
+			r := BlockReport{
+				NumCodewords: b.numCodewords,
+				Capacity:     q.version.errorCapacity(b),
+			}
+
+			for _, index := range blocks[blockID] {
+				if wrongCodeword[index] {
+					r.NumErrors++
+				}
+			}
+
+			report.Blocks = append(report.Blocks, r)
+			blockID++
+		}
+	}
+
+	for i, b := range report.Blocks {
+		if b.Margin() < 0 {
+			return report, &UnscannableError{
+				Report: report,
+				Reason: fmt.Sprintf("block %d has %d codeword errors, %d correctable", i,
+					b.NumErrors, b.Capacity),
+			}
+		}
+	}
+
+	decoded, err := decodeModules(modules)
+	if err != nil {
+		return report, &UnscannableError{Report: report, Reason: err.Error()}
+	} else if decoded.Content != q.Content {
+		return report, &UnscannableError{Report: report, Reason: "content decoded incorrectly"}
+	}
+
+	return report, nil
+}
+
+// sampleModules reads the modules of the symbol, without quiet zone, from the
+// centers of the modules drawn in area of img.
+func (q *HalftoneQRCode) sampleModules(img image.Image, area image.Rectangle) ([][]bool, error) {
+	// Without the Size option the modules fill the image, in units of modules.
+	moduleWidth, padding, size := 1, 0, q.symbol.size
+	if q.option.Size != 0 {
+		var err error
+		if moduleWidth, padding, size, err = q.layout(0); err != nil {
+			return nil, err
+		}
+	}
+
+	quietZone := q.symbol.quietZoneSize
+
+	modules := make([][]bool, q.symbol.symbolSize)
+	for y := range modules {
+		modules[y] = make([]bool, q.symbol.symbolSize)
+
+		for x := range modules[y] {
+			px := area.Min.X + (2*(padding+(x+quietZone)*moduleWidth)+moduleWidth)*area.Dx()/(2*size)
+			py := area.Min.Y + (2*(padding+(y+quietZone)*moduleWidth)+moduleWidth)*area.Dy()/(2*size)
+
+			modules[y][x] = q.isForeground(img.At(px, py))
+		}
+	}
+
+	return modules, nil
+}
+
+// luminanceThreshold returns the luminance halfway between the luminances of
+// the foreground and background colours. With ForegroundFill, the foreground
+// colour is the lightest colour meeting the required contrast.
+func (q *HalftoneQRCode) luminanceThreshold() int {
+	if q.option.ForegroundFill != nil {
+		return (q.contrastThreshold() + luminance(q.option.BackgroundColor)) / 2
+	}
+
+	return (luminance(q.option.ForegroundColor) + luminance(q.option.BackgroundColor)) / 2
+}
+
+// isForeground reports whether c reads as the foreground colour rather than the
+// background colour, by its luminance.
+func (q *HalftoneQRCode) isForeground(c color.Color) bool {
+	dark := luminance(c) < q.luminanceThreshold()
+
+	return dark == (luminance(q.option.ForegroundColor) <= luminance(q.option.BackgroundColor))
+}
@@ -0,0 +1,96 @@
+package qart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := q.CodeImage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := q.Verify(img)
+	if err != nil {
+		t.Fatalf("got %s, expected success", err.Error())
+	}
+
+	if report.NumDataErrors != 0 || report.NumFunctionErrors != 0 || report.Score() != 1 {
+		t.Errorf("got %d data errors %d function errors score %f, expected none",
+			report.NumDataErrors, report.NumFunctionErrors, report.Score())
+	}
+
+	// Paint over the bottom right corner of the code.
+	damaged := image.NewRGBA(img.Bounds())
+	draw.Draw(damaged, damaged.Rect, img, image.Point{}, draw.Src)
+
+	size := img.Bounds().Dx()
+	corner := image.Rect(size*3/4, size*3/4, size, size)
+	draw.Draw(damaged, corner, image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	report, err = q.Verify(damaged)
+	if err != nil {
+		t.Fatalf("got %s, expected success", err.Error())
+	}
+
+	if report.NumDataErrors == 0 || report.MinMargin() >= report.Blocks[0].Capacity {
+		t.Errorf("got %d data errors margin %d, expected errors", report.NumDataErrors,
+			report.MinMargin())
+	}
+
+	// Paint over most of the code.
+	draw.Draw(damaged, image.Rect(0, size/3, size, size), image.NewUniform(color.White),
+		image.Point{}, draw.Src)
+
+	report, err = q.Verify(damaged)
+	if e, ok := err.(*UnscannableError); !ok {
+		t.Fatalf("got %v, expected *UnscannableError", err)
+	} else if e.Report != report || report.MinMargin() >= 0 || report.Score() >= 0 {
+		t.Errorf("got margin %d score %f, expected negative", report.MinMargin(), report.Score())
+	}
+}
+
+func TestImageDataVerify(t *testing.T) {
+	q, err := NewHalftoneCode("http://www.example.org", Highest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.AddOption(Option{MaskImageFile: bytes.NewReader(testMaskImage()), Verify: true})
+
+	if _, err := q.ImageData(3); err != nil {
+		t.Errorf("got %s, expected success", err.Error())
+	}
+
+	q.AddOption(Option{
+		MaskImageFile: bytes.NewReader(testMaskImage()),
+		MaskRectangle: image.Rect(20, 20, 280, 280),
+		Embed:         true,
+	})
+
+	if _, err := q.ImageData(3); err != nil {
+		t.Errorf("embedded got %s, expected success", err.Error())
+	}
+
+	// Embedded in a rectangle too small to hold the modules.
+	q.AddOption(Option{
+		MaskImageFile: bytes.NewReader(testMaskImage()),
+		MaskRectangle: image.Rect(10, 10, 30, 30),
+		Embed:         true,
+	})
+
+	if _, err := q.ImageData(3); err == nil {
+		t.Error("got success, expected error")
+	} else if _, ok := err.(*UnscannableError); !ok {
+		t.Errorf("got %s, expected *UnscannableError", err.Error())
+	}
+}
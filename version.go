@@ -2960,17 +2960,18 @@ func (v qrCodeVersion) numDataBits() int {
 // data length in bits, the error recovery level required, and the data encoder
 // used.
 //
-// The chosen QR Code version is the smallest version able to fit numDataBits
-// and the optional terminator bits required by the specified encoder.
+// The chosen QR Code version is the smallest version, not smaller than
+// minVersion, able to fit numDataBits and the optional terminator bits required
+// by the specified encoder.
 //
 // On success the chosen QR Code version is returned.
-func chooseQRCodeVersion(level RecoveryLevel, encoder *dataEncoder, numDataBits int) *qrCodeVersion {
+func chooseQRCodeVersion(level RecoveryLevel, encoder *dataEncoder, numDataBits int, minVersion int) *qrCodeVersion {
 	var chosenVersion *qrCodeVersion
 
 	for _, v := range versions {
 		if v.level != level {
 			continue
-		} else if v.version < encoder.minVersion {
+		} else if v.version < encoder.minVersion || v.version < minVersion {
 			continue
 		} else if v.version > encoder.maxVersion {
 			break
@@ -3013,6 +3014,28 @@ func (v qrCodeVersion) numBlocks() int {
 	return numBlocks
 }
 
+// misdecodeProtection returns the number of error correction codewords of each
+// block which the smallest versions keep to detect misdecodes, rather than to
+// correct errors. See ISO/IEC 18004 Table 9.
+func (v qrCodeVersion) misdecodeProtection() int {
+	switch {
+	case v.version == 1 && v.level == Low:
+		return 3
+	case v.version == 1 && v.level == Medium, v.version == 2 && v.level == Low:
+		return 2
+	case v.version == 1, v.version == 3 && v.level == Low:
+		return 1
+	}
+
+	return 0
+}
+
+// errorCapacity returns the number of codeword errors a block b of the version
+// corrects.
+func (v qrCodeVersion) errorCapacity(b block) int {
+	return (b.numCodewords - b.numDataCodewords - v.misdecodeProtection()) / 2
+}
+
 // numBitsToPadToCodeword returns the number of bits required to pad data of
 // length numDataBits upto the nearest codeword size.
 func (v qrCodeVersion) numBitsToPadToCodeword(numDataBits int) int {
@@ -154,3 +154,33 @@ func TestNumBitsToPadToCodeoword(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorCapacity(t *testing.T) {
+	// Correctable codeword errors of the first block, from ISO/IEC 18004
+	// Table 9.
+	tests := []struct {
+		level   RecoveryLevel
+		version int
+
+		expected int
+	}{
+		{Low, 1, 2},
+		{Medium, 1, 4},
+		{High, 1, 6},
+		{Highest, 1, 8},
+		{Low, 2, 4},
+		{Medium, 2, 8},
+		{Low, 3, 7},
+		{Low, 4, 10},
+		{Highest, 40, 15},
+	}
+
+	for _, test := range tests {
+		v := getQRCodeVersion(test.level, test.version)
+
+		if result := v.errorCapacity(v.block[0]); result != test.expected {
+			t.Errorf("errorCapacity (version=%d level=%d), got %d, expected %d", test.version, test.level,
+				result, test.expected)
+		}
+	}
+}